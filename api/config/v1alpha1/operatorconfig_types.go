@@ -0,0 +1,38 @@
+// Copyright Red Hat
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig is the configuration file of the idp-strategy-operator.
+// The manager settings, including the syncPeriod of the informers, are the ones of
+// the controller-runtime ControllerManagerConfiguration.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// DefaultStrategy is the strategy type of the Strategies which don't set one
+	DefaultStrategy identitatemv1alpha1.StrategyType `json:"defaultStrategy,omitempty"`
+
+	// RedirectTemplate is the Go template of the redirect URI of the DexClients.
+	// The template receives the Scheme and the AppsHost of the hub, the ClusterName
+	// and the IdentityProvider name.
+	RedirectTemplate string `json:"redirectTemplate,omitempty"`
+
+	// RotationPeriod is the maximum age of the generated client secrets,
+	// the secrets are never rotated if not set
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
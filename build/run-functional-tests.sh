@@ -84,6 +84,7 @@ kind load docker-image ${DOCKER_IMAGE_AND_TAG} --name=${CLUSTER_NAME} -v 99 || e
 
 echo "install cluster"
 make functional-test-crds
+make install
 make deploy-coverage
 echo "Wait deployment stabilize"
 sleep 10
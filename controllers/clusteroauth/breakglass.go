@@ -0,0 +1,227 @@
+// Copyright Red Hat
+
+package clusteroauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openshiftconfigv1 "github.com/openshift/api/config/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// BreakGlassSecretName is the name of the hub secret holding the break-glass credentials
+	// of a managed cluster in the cluster namespace, and the name of the htpasswd secret
+	// delivered in the openshift-config namespace of the managed cluster.
+	BreakGlassSecretName string = "idp-break-glass"
+	// BreakGlassIdentityProviderName is the name of the htpasswd IdentityProvider added to the OAuth
+	BreakGlassIdentityProviderName string = "break-glass"
+	// BreakGlassUsername is the cluster-admin user of the break-glass IdentityProvider
+	BreakGlassUsername string = "break-glass-admin"
+	// BreakGlassRotateAnnotation set to "true" on the hub secret generates a new password
+	BreakGlassRotateAnnotation string = "identityconfig.identitatem.io/rotate-break-glass"
+
+	breakGlassUsernameKey = "username"
+	breakGlassPasswordKey = "password"
+	breakGlassHTPasswdKey = "htpasswd"
+
+	openshiftConfigNamespace = "openshift-config"
+)
+
+// newBreakGlassCredentials generates a password and the matching htpasswd file
+func newBreakGlassCredentials() (map[string][]byte, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		breakGlassUsernameKey: []byte(BreakGlassUsername),
+		breakGlassPasswordKey: []byte(password),
+		breakGlassHTPasswdKey: []byte(fmt.Sprintf("%s:%s\n", BreakGlassUsername, hash)),
+	}, nil
+}
+
+// syncBreakGlassSecret creates the break-glass secret of the cluster on the hub when the feature is enabled,
+// rotates its password on request and deletes it when the feature is disabled.
+func (r *ClusterOAuthReconciler) syncBreakGlassSecret(ctx context.Context, namespace string) error {
+	secret := &corev1.Secret{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: BreakGlassSecretName, Namespace: namespace}, secret)
+	switch {
+	case errors.IsNotFound(err):
+		if !r.BreakGlass {
+			return nil
+		}
+		data, err := newBreakGlassCredentials()
+		if err != nil {
+			return err
+		}
+		secret = newBreakGlassSecret(namespace, data)
+		if err := helpers.Apply(ctx, r.Client, secret, helpers.ClusterOAuthFieldManager); err != nil {
+			return err
+		}
+		r.recordBreakGlassEvent(secret, "BreakGlassCreated",
+			fmt.Sprintf("break-glass credentials generated for user %s on cluster %s", BreakGlassUsername, namespace))
+		return nil
+	case err != nil:
+		return err
+	case !isBreakGlassSecret(secret):
+		//A secret the operator didn't generate is neither delivered, rotated nor deleted
+		r.Log.Info("The break-glass secret is not managed by the operator, delete it to generate the credentials",
+			"namespace", namespace)
+		return nil
+	case !r.BreakGlass:
+		return r.deleteBreakGlassSecret(ctx, secret)
+	case secret.GetAnnotations()[BreakGlassRotateAnnotation] != "true":
+		return nil
+	}
+
+	data, err := newBreakGlassCredentials()
+	if err != nil {
+		return err
+	}
+	rotated := newBreakGlassSecret(namespace, data)
+	//The annotation is set by the user, the apply can't remove it and so takes it over and resets it
+	rotated.Annotations = map[string]string{BreakGlassRotateAnnotation: "false"}
+	if err := helpers.Apply(ctx, r.Client, rotated, helpers.ClusterOAuthFieldManager); err != nil {
+		return err
+	}
+	r.recordBreakGlassEvent(rotated, "BreakGlassRotated",
+		fmt.Sprintf("break-glass credentials rotated for user %s on cluster %s", BreakGlassUsername, namespace))
+	return nil
+}
+
+// deleteBreakGlassSecret deletes the break-glass secret of the cluster from the hub,
+// nil if the cluster has none or if the secret is not managed by the operator
+func (r *ClusterOAuthReconciler) deleteBreakGlassSecret(ctx context.Context, secret *corev1.Secret) error {
+	if secret == nil || !isBreakGlassSecret(secret) {
+		return nil
+	}
+	if err := r.Client.Delete(ctx, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	r.recordBreakGlassEvent(secret, "BreakGlassDeleted",
+		fmt.Sprintf("break-glass credentials removed from cluster %s", secret.Namespace))
+	return nil
+}
+
+// newBreakGlassSecret returns the break-glass secret of the cluster on the hub with the credentials
+func newBreakGlassSecret(namespace string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BreakGlassSecretName,
+			Namespace: namespace,
+			Labels:    helpers.ClusterAggregateLabels(),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+func (r *ClusterOAuthReconciler) recordBreakGlassEvent(secret *corev1.Secret, reason, message string) {
+	r.Log.Info(message, "reason", reason)
+	if r.Recorder != nil {
+		r.Recorder.Event(secret, corev1.EventTypeNormal, reason, message)
+	}
+}
+
+// isBreakGlassSecret returns true if the object is a break-glass secret generated by the operator
+func isBreakGlassSecret(o client.Object) bool {
+	return o.GetName() == BreakGlassSecretName && helpers.IsClusterAggregate(o)
+}
+
+// getBreakGlassSecret returns the break-glass secret of the cluster,
+// nil if the cluster has none or if the secret is not managed by the operator
+func getBreakGlassSecret(ctx context.Context, c client.Client, namespace string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: BreakGlassSecretName, Namespace: namespace}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !isBreakGlassSecret(secret) {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+// breakGlassIdentityProvider returns the htpasswd IdentityProvider of the break-glass user
+func breakGlassIdentityProvider() openshiftconfigv1.IdentityProvider {
+	return openshiftconfigv1.IdentityProvider{
+		Name:          BreakGlassIdentityProviderName,
+		MappingMethod: openshiftconfigv1.MappingMethodClaim,
+		IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+			Type: openshiftconfigv1.IdentityProviderTypeHTPasswd,
+			HTPasswd: &openshiftconfigv1.HTPasswdIdentityProvider{
+				FileData: openshiftconfigv1.SecretNameReference{
+					Name: BreakGlassSecretName,
+				},
+			},
+		},
+	}
+}
+
+// breakGlassObjects returns the objects delivering the break-glass user to the managed cluster,
+// only the htpasswd file leaves the hub, the password stays in the hub secret.
+func breakGlassObjects(hubSecret *corev1.Secret) []runtime.Object {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BreakGlassSecretName,
+			Namespace: openshiftConfigNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			breakGlassHTPasswdKey: hubSecret.Data[breakGlassHTPasswdKey],
+		},
+	}
+	clusterRoleBinding := &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: BreakGlassSecretName + "-cluster-admin",
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     "cluster-admin",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.UserKind,
+				Name:     BreakGlassUsername,
+			},
+		},
+	}
+	return []runtime.Object{secret, clusterRoleBinding}
+}
@@ -4,29 +4,28 @@ package clusteroauth
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	//"fmt"
-	"reflect"
+	"time"
 
 	//"github.com/prometheus/common/log"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/go-logr/logr"
-	"github.com/identitatem/idp-client-api/api/client/clientset/versioned/scheme"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 
 	// identitatemdexserverv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
@@ -39,13 +38,13 @@ import (
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
-	idpconfig "github.com/identitatem/idp-client-api/config"
 	openshiftconfigv1 "github.com/openshift/api/config/v1"
 
 	//+kubebuilder:scaffold:imports
-
-	clusteradmapply "open-cluster-management.io/clusteradm/pkg/helpers/apply"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+	"github.com/identitatem/idp-strategy-operator/pkg/manifestwork"
 )
 
 // ClusterOAuthReconciler reconciles a Strategy object
@@ -56,16 +55,46 @@ type ClusterOAuthReconciler struct {
 	APIExtensionClient apiextensionsclient.Interface
 	Log                logr.Logger
 	Scheme             *runtime.Scheme
+	// ReconcileTimeout is the deadline of a reconcile, no deadline if zero
+	ReconcileTimeout time.Duration
+	// ControllerOptions sets the concurrency and the rate limiter of the controller
+	ControllerOptions controller.Options
+	Recorder          record.EventRecorder
+	// RollbackWindow is the duration after an update of the ManifestWork during which
+	// a failure on the managed cluster rolls the ManifestWork back to the last known-good revision.
+	// The automatic rollback is disabled if zero.
+	RollbackWindow time.Duration
+	// BreakGlass adds a break-glass htpasswd IdentityProvider to the OAuth of the managed clusters
+	BreakGlass bool
 }
 
 var log = logf.Log.WithName("utils")
 
+const (
+	// RolloutRevisionAnnotation is set on the ClusterOAuth by the Strategy generating it
+	// with the rollout revision of its configuration
+	RolloutRevisionAnnotation string = "identityconfig.identitatem.io/rollout-revision"
+	// RolloutTimestampAnnotation is set on the ClusterOAuth when its rollout revision changes
+	RolloutTimestampAnnotation string = "identityconfig.identitatem.io/rollout-timestamp"
+	// DeliveredRolloutRevisionAnnotation is set on the ClusterOAuth with the rollout revision
+	// included in the manifests of the ManifestWork
+	DeliveredRolloutRevisionAnnotation string = "identityconfig.identitatem.io/delivered-rollout-revision"
+	// DeliveredManifestsRevisionAnnotation is set on the ClusterOAuth with the revision of the manifests
+	// of the ManifestWork which include its rollout revision
+	DeliveredManifestsRevisionAnnotation string = "identityconfig.identitatem.io/delivered-manifests-revision"
+)
+
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={authrealms,strategies,clusteroauths},verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={strategies/status,clusteroauths/status},verbs=get;update;patch
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/finalizers,verbs=update
-// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list;create;update;patch;delete
+// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list
 
 //+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources={placements,placementdecisions},verbs=get;list;watch;create;update;patch;delete;watch
+//+kubebuilder:rbac:groups=work.open-cluster-management.io,resources=manifestworks,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -77,30 +106,35 @@ var log = logf.Log.WithName("utils")
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *ClusterOAuthReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
 	_ = r.Log.WithValues("clusteroauth", req.NamespacedName)
 
-	// your logic here
-	// Fetch the ClusterOAuth instance
-	instance := &identitatemv1alpha1.ClusterOAuth{}
-
-	if err := r.Client.Get(
-		context.TODO(),
-		types.NamespacedName{Namespace: req.Namespace, Name: req.Name},
-		instance,
-	); err != nil {
-		if errors.IsNotFound(err) {
-			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
-			// Return and don't requeue
-			return reconcile.Result{}, nil
-		}
-		// Error reading the object - requeue the request.
+	// The reconciles are keyed by cluster namespace as all ClusterOAuths of a cluster
+	// are consolidated in the same ManifestWork, so the ManifestWork of a cluster is
+	// never reconciled by 2 workers at the same time.
+	clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+	if err := r.Client.List(ctx, clusterOAuths, client.InNamespace(req.Namespace)); err != nil {
 		return reconcile.Result{}, err
 	}
-
-	r.Log.Info("Instance", "instance", instance)
-	r.Log.Info("Running Reconcile for ClusterOAuth.", "Name: ", instance.GetName(), " Namespace:", instance.GetNamespace())
+	if len(clusterOAuths.Items) == 0 {
+		// The cluster has no ClusterOAuth left, the password of the break-glass user must not stay on the hub
+		secret, err := getBreakGlassSecret(ctx, r.Client, req.Namespace)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := r.deleteBreakGlassSecret(ctx, secret); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := deleteControllerRevisions(ctx, r.Client, req.Namespace); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, manifestwork.Prune(ctx, r.Client, req.Namespace, helpers.ClusterOAuthFieldManager)
+	}
+	r.Log.Info("Running Reconcile for ClusterOAuth.", "Namespace:", req.Namespace, "ClusterOAuths:", len(clusterOAuths.Items))
 
 	//TODO   - I think this only applies to backplane so no need to check
 	//         If grc also uses this, we need to have a way of knowing what strategy type created
@@ -109,105 +143,63 @@ func (r *ClusterOAuthReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	//switch instance.Spec.Type {
 	//case identitatemv1alpha1.BackplaneStrategyType:
 
-	// Create empty manifest work
-	manifestWork := &manifestworkv1.ManifestWork{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "idp-backplane",
-			Namespace: instance.GetNamespace(),
-		},
-		Spec: manifestworkv1.ManifestWorkSpec{
-			Workload: manifestworkv1.ManifestsTemplate{
-				Manifests: []manifestworkv1.Manifest{},
-			},
-		},
+	if err := r.syncBreakGlassSecret(ctx, req.Namespace); err != nil {
+		return reconcile.Result{}, err
 	}
 
-	// Get a list of all clusterOAuth
-	clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
-	//	singleOAuth := &openshiftconfigv1.OAuth{}
-	singleOAuth := &openshiftconfigv1.OAuth{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: openshiftconfigv1.SchemeGroupVersion.String(),
-			Kind:       "OAuth",
-		},
-
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "idp-backplane-oauth",
-			Namespace: instance.GetNamespace(),
-		},
-
-		Spec: openshiftconfigv1.OAuthSpec{},
+	manifestWork, referenceErrors, err := BuildManifestWork(ctx, r.Client, req.Namespace, clusterOAuths.Items)
+	if err != nil {
+		return reconcile.Result{}, err
 	}
-
-	if err := r.List(context.TODO(), clusterOAuths, &client.ListOptions{Namespace: instance.GetNamespace()}); err != nil {
-		// Error reading the object - requeue the request.
+	if err := r.setReferencesResolvedCondition(ctx, clusterOAuths, referenceErrors); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	for _, clusterOAuth := range clusterOAuths.Items {
-		//build OAuth and add to manifest work
-		r.Log.Info("ClusterOAuth.", "Name: ", clusterOAuth.GetName(), " Namespace:", instance.GetNamespace(), "IdentityProviders:", len(instance.Spec.OAuth.Spec.IdentityProviders))
-
-		for j, idp := range instance.Spec.OAuth.Spec.IdentityProviders {
-
-			r.Log.Info("ClusterOAuth.", "IdentityProvider  ", j, " Name:", idp.Name)
-
-			//build oauth by appending first clusterOAuth entry into single OAuth
-			singleOAuth.Spec.IdentityProviders = append(singleOAuth.Spec.IdentityProviders, idp)
-
-			//Look for secret for Identity Provider and if found, add to manifest work
-			secret := &corev1.Secret{}
-
-			if err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: req.Namespace, Name: idp.Name}, secret); err == nil {
-				//add secret to manifest
-
-				//TODO TEMP PATCH
-				if len(secret.TypeMeta.Kind) == 0 {
-					secret.TypeMeta.Kind = "Secret"
-
-				}
-				if len(secret.TypeMeta.APIVersion) == 0 {
-					secret.TypeMeta.APIVersion = corev1.SchemeGroupVersion.String()
-
-				}
-
-				data, err := json.Marshal(secret)
-				if err != nil {
-					return reconcile.Result{}, err
-				}
-
-				manifest := manifestworkv1.Manifest{
-					RawExtension: runtime.RawExtension{Raw: data},
-				}
-
-				//add manifest to manifest work
-				manifestWork.Spec.Workload.Manifests = append(manifestWork.Spec.Workload.Manifests, manifest)
+	revision := manifestWork.GetAnnotations()[ManifestsRevisionAnnotation]
 
-			}
+	currentManifestWork, err := manifestwork.Get(ctx, r.Client, client.ObjectKeyFromObject(manifestWork))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if currentManifestWork != nil {
+		rolledBack, err := r.checkManifestWork(ctx, currentManifestWork, manifestWork.Spec.Workload.Manifests)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		// Don't apply again the revision which failed until the ClusterOAuths change
+		if rolledBack || currentManifestWork.GetAnnotations()[RolledBackRevisionAnnotation] == revision {
+			return reconcile.Result{}, nil
 		}
 	}
 
-	// create manifest for single OAuth
-	data, err := json.Marshal(singleOAuth)
-	if err != nil {
+	manifestWork.Annotations[ManifestsAppliedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	// create manifest work for managed cluster, the RolledBackRevisionAnnotation is removed by the apply
+	if _, err := manifestwork.Apply(ctx, r.Client, manifestWork, helpers.ClusterOAuthFieldManager); err != nil {
+		r.Log.Error(err, "Failed to create manifest work for component")
+		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 
-	manifest := manifestworkv1.Manifest{
-		RawExtension: runtime.RawExtension{Raw: data},
+	if err := manifestwork.Prune(ctx, r.Client, req.Namespace, helpers.ClusterOAuthFieldManager, BackplaneManifestWorkName); err != nil {
+		return reconcile.Result{}, err
 	}
 
-	//add OAuth manifest to manifest work
-	manifestWork.Spec.Workload.Manifests = append(manifestWork.Spec.Workload.Manifests, manifest)
+	if err := recordControllerRevision(ctx, r.Client, req.Namespace, revision, manifestWork.Spec.Workload.Manifests); err != nil {
+		return reconcile.Result{}, err
+	}
 
-	// create manifest work for managed cluster
-	// (borrowed from https://github.com/open-cluster-management/endpoint-operator/blob/master/pkg/utils/utils.go)
-	if err := CreateOrUpdateManifestWork(manifestWork, r.Client, manifestWork, r.Scheme); err != nil {
-		r.Log.Error(err, "Failed to create manifest work for component")
-		// Error reading the object - requeue the request.
+	if err := recordDeliveredRevisions(ctx, r.Client, clusterOAuths.Items, revision); err != nil {
 		return reconcile.Result{}, err
 	}
 
+	if currentManifestWork != nil && currentManifestWork.GetAnnotations()[ManifestsRevisionAnnotation] != revision {
+		if err := r.setRolledBackCondition(ctx, req.Namespace, metav1.ConditionFalse, "RevisionApplied",
+			fmt.Sprintf("revision %s applied", revision)); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
 	//case identitatemv1alpha1..GRCStrategyType:
 	//default:
 	//	return reconcile.Result{}, fmt.Errorf("strategy type %s not supported", instance.Spec.Type)
@@ -216,206 +208,146 @@ func (r *ClusterOAuthReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	return ctrl.Result{}, nil
 }
 
-// compareManifestWorks returns true if 2 manifestworks' specs are the same
-func compareManifestWorks(mw1 *manifestworkv1.ManifestWork, mw2 *manifestworkv1.ManifestWork) bool {
-	if mw1 == nil && mw2 == nil {
-		return true
-	}
-	if (mw1 == nil && mw2 != nil) || (mw2 == nil && mw1 != nil) {
-		return false
-	}
-	if len(mw1.Spec.Workload.Manifests) != len(mw2.Spec.Workload.Manifests) {
-		return false
-	}
-	used := make(map[int]bool)
-	for _, m1 := range mw1.Spec.Workload.Manifests {
-		hasMatch := false
-		for j, m2 := range mw2.Spec.Workload.Manifests {
-			if used[j] {
-				continue
-			}
-			if compareManifests(&m1.RawExtension, &m2.RawExtension) {
-				hasMatch = true
-				used[j] = true
-				break
-			}
+// recordDeliveredRevisions records on the ClusterOAuths their rollout revision delivered by the manifests
+// of the ManifestWork, the Strategies read them to follow their rollout on the cluster
+func recordDeliveredRevisions(ctx context.Context, c client.Client,
+	clusterOAuths []identitatemv1alpha1.ClusterOAuth,
+	manifestsRevision string) error {
+	for i := range clusterOAuths {
+		annotations := clusterOAuths[i].GetAnnotations()
+		rolloutRevision, ok := annotations[RolloutRevisionAnnotation]
+		if !ok ||
+			(annotations[DeliveredRolloutRevisionAnnotation] == rolloutRevision &&
+				annotations[DeliveredManifestsRevisionAnnotation] == manifestsRevision) {
+			continue
 		}
-		if !hasMatch {
-			return false
+		if err := helpers.ApplyFields(ctx, c,
+			identitatemv1alpha1.SchemeGroupVersion.WithKind("ClusterOAuth"),
+			client.ObjectKeyFromObject(&clusterOAuths[i]),
+			map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						DeliveredRolloutRevisionAnnotation:   rolloutRevision,
+						DeliveredManifestsRevisionAnnotation: manifestsRevision,
+					},
+				},
+			},
+			helpers.ClusterOAuthFieldManager); err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
 }
 
-// convertRawExtensiontoUnstructured converts a rawExtension to a unstructured object
-func convertRawExtensiontoUnstructured(r *runtime.RawExtension) (*unstructured.Unstructured, error) {
-	if r == nil {
-		return nil, fmt.Errorf("fail to convert rawExtension")
-	}
-	var obj runtime.Object
-	var scope conversion.Scope
-	err := runtime.Convert_runtime_RawExtension_To_runtime_Object(r, &obj, scope)
-	if err != nil {
-		log.Error(err, "failed to convert rawExtension to runtime.Object", "rawExtension", r)
-		return nil, err
+// newManifestWorkBuilder returns the builder of the ManifestWork of the managed cluster,
+// the builder of a rolled back ManifestWork must set the same labels or the apply removes them.
+func newManifestWorkBuilder(scheme *runtime.Scheme, namespace string) *manifestwork.Builder {
+	builder := manifestwork.NewBuilder(scheme, BackplaneManifestWorkName, namespace)
+	for key, value := range helpers.ClusterAggregateLabels() {
+		builder.WithLabel(key, value)
 	}
-	if obj == nil {
-		return nil, nil
-	}
-	innerObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
-	if err != nil {
-		log.Error(err, "failed to convert runtime.Objectt to Unstructured", "runtime.Object", &obj)
-		return nil, err
-	}
-	u := unstructured.Unstructured{Object: innerObj}
-	return &u, nil
+	return builder
 }
 
-var rootAttributes = []string{
-	"spec",
-	"rules",
-	"roleRef",
-	"subjects",
-	"secrets",
-	"imagePullSecrets",
-	"automountServiceAccountToken",
-	"data",
-}
+// BuildManifestWork builds the ManifestWork for a managed cluster by consolidating
+// the ClusterOAuths of the cluster namespace into one OAuth with the secrets of the IdentityProviders.
+// It doesn't write anything and so it can be used to preview the ManifestWork of given ClusterOAuths.
+// The errors of the references which can't be resolved and of the IdentityProvider names already used
+// in the OAuth are returned by ClusterOAuth name, the IdentityProviders in error are left out.
+func BuildManifestWork(ctx context.Context, c client.Client, namespace string,
+	clusterOAuths []identitatemv1alpha1.ClusterOAuth) (*manifestworkv1.ManifestWork, map[string][]error, error) {
+	// Create empty manifest work
+	// The ManifestWork aggregates the ClusterOAuths of all AuthRealms, it is an orphan once the cluster has no ClusterOAuth
+	builder := newManifestWorkBuilder(c.Scheme(), namespace)
 
-// compareManifests compares if 2 manifests are the same, it only checks value we care
-// (name/namespace/kind/group/spec/data)
-func compareManifests(r1, r2 *runtime.RawExtension) bool {
-	u1, err := convertRawExtensiontoUnstructured(r1)
-	if err != nil {
-		return false
+	//	singleOAuth := &openshiftconfigv1.OAuth{}
+	singleOAuth := &openshiftconfigv1.OAuth{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: openshiftconfigv1.SchemeGroupVersion.String(),
+			Kind:       "OAuth",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "idp-backplane-oauth",
+			Namespace: namespace,
+		},
+
+		Spec: openshiftconfigv1.OAuthSpec{},
 	}
-	u2, err := convertRawExtensiontoUnstructured(r2)
-	if err != nil {
-		return false
-	}
-	if u1 == nil || u2 == nil {
-		return u2 == nil && u1 == nil
-	}
-	if u1.GetName() != u2.GetName() ||
-		u1.GetNamespace() != u2.GetNamespace() ||
-		u1.GetKind() != u2.GetKind() ||
-		u1.GetAPIVersion() != u2.GetAPIVersion() {
-		return false
-	}
-	hasDiff := false
-	for _, r := range rootAttributes {
-		if newValue, ok := u2.Object[r]; ok {
-			if !reflect.DeepEqual(newValue, u1.Object[r]) {
-				hasDiff = true
-			}
-		} else {
-			if _, ok := u1.Object[r]; ok {
-				hasDiff = true
-			}
+
+	referenceErrors := make(map[string][]error)
+	// the ClusterOAuth delivering each IdentityProvider name, the name of the break-glass IdentityProvider is reserved
+	idpNames := map[string]string{BreakGlassIdentityProviderName: ""}
+
+	for _, clusterOAuth := range clusterOAuths {
+		if clusterOAuth.Spec.OAuth == nil {
+			continue
 		}
-	}
-	return !hasDiff
-}
+		//build OAuth and add to manifest work
+		log.Info("ClusterOAuth.", "Name: ", clusterOAuth.GetName(), " Namespace:", namespace, "IdentityProviders:", len(clusterOAuth.Spec.OAuth.Spec.IdentityProviders))
+
+		for j := range clusterOAuth.Spec.OAuth.Spec.IdentityProviders {
+			idp := clusterOAuth.Spec.OAuth.Spec.IdentityProviders[j].DeepCopy()
+
+			log.Info("ClusterOAuth.", "IdentityProvider  ", j, " Name:", idp.Name)
 
-// CreateOrUpdateManifestWork creates a new ManifestWork or update an existing ManifestWork
-func CreateOrUpdateManifestWork(
-	manifestwork *manifestworkv1.ManifestWork,
-	client client.Client,
-	owner metav1.Object,
-	scheme *runtime.Scheme,
-) error {
-	var oldManifestwork manifestworkv1.ManifestWork
-
-	err := client.Get(
-		context.TODO(),
-		types.NamespacedName{Name: manifestwork.Name, Namespace: manifestwork.Namespace},
-		&oldManifestwork,
-	)
-	if err == nil {
-		// Check if update is require
-		if !compareManifestWorks(&oldManifestwork, manifestwork) {
-			oldManifestwork.Spec.Workload.Manifests = manifestwork.Spec.Workload.Manifests
-			if err := client.Update(context.TODO(), &oldManifestwork); err != nil {
-				log.Error(err, "Fail to update manifestwork")
-				return err
+			if owner, ok := idpNames[idp.Name]; ok {
+				referenceErrors[clusterOAuth.Name] = append(referenceErrors[clusterOAuth.Name],
+					&NameCollisionError{IdentityProvider: idp.Name, ClusterOAuth: owner})
+				continue
 			}
-		}
-	} else {
-		if errors.IsNotFound(err) {
-			//if err := controllerutil.SetControllerReference(owner, manifestwork, scheme); err != nil {
-			//	log.Error(err, "Unable to SetControllerReference")
-			//	return err
-			//}
-			if err := client.Create(context.TODO(), manifestwork); err != nil {
-				log.Error(err, "Fail to create manifestwork")
-				return err
+			idpNames[idp.Name] = clusterOAuth.Name
+
+			//Deliver the secrets and configmaps referenced by the Identity Provider in openshift-config
+			//and reference the delivered objects. The Identity Provider is left out of the OAuth
+			//while a referenced object is missing on the hub, as the OAuth would not be valid.
+			objs, errs, err := resolveReferences(ctx, c, namespace, idp)
+			if err != nil {
+				return nil, nil, err
 			}
-			return nil
+			if len(errs) != 0 {
+				for _, err := range errs {
+					referenceErrors[clusterOAuth.Name] = append(referenceErrors[clusterOAuth.Name], err)
+				}
+				continue
+			}
+			//add manifests to manifest work
+			builder.Add(objs...)
+
+			//build oauth by appending first clusterOAuth entry into single OAuth
+			singleOAuth.Spec.IdentityProviders = append(singleOAuth.Spec.IdentityProviders, *idp)
 		}
-		return err
 	}
 
-	return nil
-}
-
-// DeleteManifestWork deletes a manifestwork
-// if removeFinalizers is set to true, will remove all finalizers to make sure it can be deleted
-func DeleteManifestWork(name, namespace string, client client.Client, removeFinalizers bool) error {
-	manifestWork := &manifestworkv1.ManifestWork{}
-	var retErr error
-	if err := client.Get(
-		context.TODO(),
-		types.NamespacedName{Name: name, Namespace: namespace},
-		manifestWork,
-	); err != nil {
-		return err
+	// the groups synchronized by the strategies are delivered with the OAuth
+	groups, err := buildGroups(ctx, c, namespace)
+	if err != nil {
+		return nil, nil, err
 	}
+	builder.Add(groups...)
 
-	if removeFinalizers && len(manifestWork.GetFinalizers()) > 0 {
-		manifestWork.SetFinalizers([]string{})
-		if err := client.Update(context.TODO(), manifestWork); err != nil {
-			log.Error(err, fmt.Sprintf("Failed to remove finalizers of Manifestwork %s in %s namespace", name, namespace))
-			retErr = err
-		}
+	// the break-glass user is always kept when the cluster has break-glass credentials
+	breakGlassSecret, err := getBreakGlassSecret(ctx, c, namespace)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	if manifestWork.DeletionTimestamp == nil {
-		err := client.Delete(context.TODO(), manifestWork)
-		if err != nil {
-			return err
-		}
+	if breakGlassSecret != nil {
+		singleOAuth.Spec.IdentityProviders = append(singleOAuth.Spec.IdentityProviders, breakGlassIdentityProvider())
+		builder.Add(breakGlassObjects(breakGlassSecret)...)
 	}
 
-	return retErr
-}
-
-func GetManifestWork(name, namespace string, client client.Client) (*manifestworkv1.ManifestWork, error) {
-	manifestWork := &manifestworkv1.ManifestWork{}
+	//add OAuth manifest to manifest work
+	builder.Add(singleOAuth)
 
-	if err := client.Get(
-		context.TODO(),
-		types.NamespacedName{Name: name, Namespace: namespace},
-		manifestWork,
-	); err != nil {
-		return nil, err
+	mw, err := builder.Build()
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return manifestWork, nil
+	return mw, referenceErrors, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterOAuthReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	//Install CRD
-	applierBuilder := &clusteradmapply.ApplierBuilder{}
-	applier := applierBuilder.WithClient(r.KubeClient, r.APIExtensionClient, r.DynamicClient).Build()
-
-	readerIDPMgmtOperator := idpconfig.GetScenarioResourcesReader()
-
-	file := "crd/bases/identityconfig.identitatem.io_clusteroauths.yaml"
-	if _, err := applier.ApplyDirectly(readerIDPMgmtOperator, nil, false, "", file); err != nil {
-		return err
-	}
-
 	if err := corev1.AddToScheme(mgr.GetScheme()); err != nil {
 		return err
 	}
@@ -436,15 +368,80 @@ func (r *ClusterOAuthReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	if err := identitatemdexv1alpha1.AddToScheme(scheme.Scheme); err != nil {
+	if err := identitatemdexv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	if err := openshiftconfigv1.AddToScheme(mgr.GetScheme()); err != nil {
 		return err
 	}
 
-	if err := openshiftconfigv1.AddToScheme(scheme.Scheme); err != nil {
+	options := r.ControllerOptions
+	options.Reconciler = r
+	c, err := controller.New("clusteroauth", mgr, options)
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &identitatemv1alpha1.ClusterOAuth{}},
+		handler.EnqueueRequestsFromMapFunc(clusterRequest(""))); err != nil {
 		return err
 	}
+	if err := c.Watch(&source.Kind{Type: &manifestworkv1.ManifestWork{}},
+		handler.EnqueueRequestsFromMapFunc(clusterRequest(BackplaneManifestWorkName))); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Secret{}},
+		handler.EnqueueRequestsFromMapFunc(func(o client.Object) []reconcile.Request {
+			if isBreakGlassSecret(o) {
+				return clusterRequest(BreakGlassSecretName)(o)
+			}
+			return r.referenceRequest("Secret")(o)
+		})); err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &corev1.ConfigMap{}},
+		handler.EnqueueRequestsFromMapFunc(func(o client.Object) []reconcile.Request {
+			if _, ok := o.GetLabels()[helpers.GroupSyncLabel]; ok {
+				return clusterRequest("")(o)
+			}
+			return r.referenceRequest("ConfigMap")(o)
+		}))
+}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&identitatemv1alpha1.ClusterOAuth{}).
-		Complete(r)
+// clusterRequest maps the objects with the given name, or any object if the name is empty,
+// to the reconcile request of their cluster namespace
+func clusterRequest(name string) handler.MapFunc {
+	return func(o client.Object) []reconcile.Request {
+		if len(name) != 0 && o.GetName() != name {
+			return nil
+		}
+		return []reconcile.Request{
+			{NamespacedName: types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: o.GetNamespace()}},
+		}
+	}
+}
+
+// referenceRequest maps the objects of the given kind referenced by an IdentityProvider of the ClusterOAuths
+// of their namespace to the reconcile request of the cluster, so the changes on the hub are delivered to the managed cluster
+func (r *ClusterOAuthReconciler) referenceRequest(kind string) handler.MapFunc {
+	return func(o client.Object) []reconcile.Request {
+		clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+		if err := r.Client.List(context.TODO(), clusterOAuths, client.InNamespace(o.GetNamespace())); err != nil {
+			r.Log.Error(err, "unable to list the ClusterOAuths", "namespace", o.GetNamespace())
+			return nil
+		}
+		for _, clusterOAuth := range clusterOAuths.Items {
+			if clusterOAuth.Spec.OAuth == nil {
+				continue
+			}
+			for i := range clusterOAuth.Spec.OAuth.Spec.IdentityProviders {
+				for _, ref := range idpReferences(&clusterOAuth.Spec.OAuth.Spec.IdentityProviders[i]) {
+					if ref.kind == kind && *ref.name == o.GetName() {
+						return clusterRequest("")(o)
+					}
+				}
+			}
+		}
+		return nil
+	}
 }
@@ -0,0 +1,280 @@
+// Copyright Red Hat
+
+package clusteroauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	openshiftconfigv1 "github.com/openshift/api/config/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// ReferencesResolvedCondition is set on the ClusterOAuths, it is false while a Secret or a ConfigMap
+	// referenced by one of their IdentityProviders is missing on the hub or the name of one of their
+	// IdentityProviders is already used in the OAuth of the cluster
+	ReferencesResolvedCondition string = "ReferencesResolved"
+)
+
+// idpReference is a reference of an IdentityProvider to a Secret or a ConfigMap of the openshift-config namespace
+type idpReference struct {
+	// field is the path of the reference in the IdentityProvider
+	field string
+	// kind is Secret or ConfigMap
+	kind string
+	// name points to the name of the reference in the IdentityProvider, empty references are ignored
+	name *string
+	// suffix is added to the name of the delivered object
+	suffix string
+}
+
+func secretReference(field string, ref *openshiftconfigv1.SecretNameReference, suffix string) idpReference {
+	return idpReference{field: field, kind: "Secret", name: &ref.Name, suffix: suffix}
+}
+
+func configMapReference(field string, ref *openshiftconfigv1.ConfigMapNameReference) idpReference {
+	return idpReference{field: field, kind: "ConfigMap", name: &ref.Name, suffix: "-ca"}
+}
+
+// remoteConnectionReferences returns the references of the connection to a remote server
+func remoteConnectionReferences(field string, info *openshiftconfigv1.OAuthRemoteConnectionInfo) []idpReference {
+	return []idpReference{
+		configMapReference(field+".ca", &info.CA),
+		secretReference(field+".tlsClientCert", &info.TLSClientCert, "-tls-client-cert"),
+		secretReference(field+".tlsClientKey", &info.TLSClientKey, "-tls-client-key"),
+	}
+}
+
+// idpReferences returns the references of the IdentityProvider to Secrets and ConfigMaps,
+// the names can be updated through the references.
+func idpReferences(idp *openshiftconfigv1.IdentityProvider) []idpReference {
+	switch {
+	case idp.HTPasswd != nil:
+		return []idpReference{
+			secretReference("htpasswd.fileData", &idp.HTPasswd.FileData, ""),
+		}
+	case idp.LDAP != nil:
+		return []idpReference{
+			secretReference("ldap.bindPassword", &idp.LDAP.BindPassword, "-bind-password"),
+			configMapReference("ldap.ca", &idp.LDAP.CA),
+		}
+	case idp.GitHub != nil:
+		return []idpReference{
+			secretReference("github.clientSecret", &idp.GitHub.ClientSecret, ""),
+			configMapReference("github.ca", &idp.GitHub.CA),
+		}
+	case idp.GitLab != nil:
+		return []idpReference{
+			secretReference("gitlab.clientSecret", &idp.GitLab.ClientSecret, ""),
+			configMapReference("gitlab.ca", &idp.GitLab.CA),
+		}
+	case idp.Google != nil:
+		return []idpReference{
+			secretReference("google.clientSecret", &idp.Google.ClientSecret, ""),
+		}
+	case idp.OpenID != nil:
+		return []idpReference{
+			secretReference("openID.clientSecret", &idp.OpenID.ClientSecret, ""),
+			configMapReference("openID.ca", &idp.OpenID.CA),
+		}
+	case idp.Keystone != nil:
+		return remoteConnectionReferences("keystone", &idp.Keystone.OAuthRemoteConnectionInfo)
+	case idp.BasicAuth != nil:
+		return remoteConnectionReferences("basicAuth", &idp.BasicAuth.OAuthRemoteConnectionInfo)
+	case idp.RequestHeader != nil:
+		return []idpReference{
+			configMapReference("requestHeader.ca", &idp.RequestHeader.ClientCA),
+		}
+	}
+	return nil
+}
+
+// ReferenceError is returned when a Secret or a ConfigMap referenced by an IdentityProvider
+// is not found in the cluster namespace of the hub
+type ReferenceError struct {
+	IdentityProvider string
+	Field            string
+	Kind             string
+	Name             string
+	Namespace        string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("identity provider %s: %s %s/%s referenced by %s not found",
+		e.IdentityProvider, e.Kind, e.Namespace, e.Name, e.Field)
+}
+
+// NameCollisionError is returned when the name of an IdentityProvider is already used in the OAuth of the cluster,
+// by an IdentityProvider of another ClusterOAuth or by the break-glass IdentityProvider which reserves its name
+type NameCollisionError struct {
+	IdentityProvider string
+	// ClusterOAuth is the ClusterOAuth delivering the IdentityProvider with the same name, empty if reserved
+	ClusterOAuth string
+}
+
+func (e *NameCollisionError) Error() string {
+	if len(e.ClusterOAuth) == 0 {
+		return fmt.Sprintf("identity provider %s: the name is reserved", e.IdentityProvider)
+	}
+	return fmt.Sprintf("identity provider %s: the name is already used by clusteroauth %s", e.IdentityProvider, e.ClusterOAuth)
+}
+
+// setReferencesResolvedCondition reports on each ClusterOAuth the references of its IdentityProviders
+// which can't be resolved and the names which collide, these IdentityProviders are not delivered
+func (r *ClusterOAuthReconciler) setReferencesResolvedCondition(ctx context.Context,
+	clusterOAuths *identitatemv1alpha1.ClusterOAuthList,
+	referenceErrors map[string][]error) error {
+	for i := range clusterOAuths.Items {
+		clusterOAuth := &clusterOAuths.Items[i]
+		condition := metav1.Condition{
+			Type:    ReferencesResolvedCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ReferencesResolved",
+			Message: "all references of the identity providers are resolved",
+		}
+		if errs := referenceErrors[clusterOAuth.Name]; len(errs) != 0 {
+			messages := make([]string, len(errs))
+			condition.Reason = "ReferenceNotFound"
+			for j, err := range errs {
+				messages[j] = err.Error()
+				if _, ok := err.(*NameCollisionError); ok {
+					condition.Reason = "NameCollision"
+				}
+			}
+			condition.Status = metav1.ConditionFalse
+			condition.Message = strings.Join(messages, "; ")
+		}
+		conditions := append([]metav1.Condition{}, clusterOAuth.Status.Conditions...)
+		meta.SetStatusCondition(&clusterOAuth.Status.Conditions, condition)
+		if equality.Semantic.DeepEqual(conditions, clusterOAuth.Status.Conditions) {
+			continue
+		}
+		if err := r.Client.Status().Update(ctx, clusterOAuth); err != nil {
+			return err
+		}
+		if r.Recorder != nil && condition.Status == metav1.ConditionFalse {
+			r.Recorder.Event(clusterOAuth, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		}
+	}
+	return nil
+}
+
+// deliveredName returns the name in the openshift-config namespace of the managed cluster
+// of an object referenced by an IdentityProvider. The names are prefixed to not collide with the objects of the cluster
+// and end with a hash of the IdentityProvider name and the suffix, as the IdentityProvider names may end like a suffix
+// and may be break-glass. The IdentityProvider name is sanitized and truncated as it may not be a valid resource name.
+func deliveredName(idpName, suffix string) string {
+	return helpers.GeneratedName(helpers.MaxNameLength, "idp", idpName, strings.TrimPrefix(suffix, "-"))
+}
+
+// resolveReferences returns the objects referenced by the IdentityProvider to deliver in the openshift-config
+// namespace of the managed cluster and updates the references with the names of the delivered objects.
+// The referenced objects are read in the cluster namespace of the hub,
+// a ReferenceError is returned for each referenced object not found.
+func resolveReferences(ctx context.Context, c client.Client, namespace string,
+	idp *openshiftconfigv1.IdentityProvider) ([]runtime.Object, []*ReferenceError, error) {
+	objs := make([]runtime.Object, 0)
+	referenceErrors := make([]*ReferenceError, 0)
+	for _, ref := range idpReferences(idp) {
+		name := *ref.name
+		if len(name) == 0 {
+			continue
+		}
+		key := client.ObjectKey{Name: name, Namespace: namespace}
+		var err error
+		var obj runtime.Object
+		switch ref.kind {
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err = c.Get(ctx, key, secret); err == nil {
+				obj = newDeliveredSecret(secret, deliveredName(idp.Name, ref.suffix))
+			}
+		case "ConfigMap":
+			configMap := &corev1.ConfigMap{}
+			if err = c.Get(ctx, key, configMap); err == nil {
+				obj = newDeliveredConfigMap(configMap, deliveredName(idp.Name, ref.suffix))
+			}
+		}
+		switch {
+		case errors.IsNotFound(err):
+			referenceErrors = append(referenceErrors, &ReferenceError{
+				IdentityProvider: idp.Name,
+				Field:            ref.field,
+				Kind:             ref.kind,
+				Name:             name,
+				Namespace:        namespace,
+			})
+			continue
+		case err != nil:
+			return nil, nil, err
+		}
+		*ref.name = deliveredName(idp.Name, ref.suffix)
+		objs = append(objs, obj)
+	}
+	return objs, referenceErrors, nil
+}
+
+// newDeliveredSecret returns the copy of a hub secret to deliver in the openshift-config namespace
+// of the managed cluster. Only the type and the data are copied, the metadata of the hub secret
+// such as its resourceVersion, uid, managedFields, ownerReferences, labels and annotations are dropped.
+func newDeliveredSecret(hubSecret *corev1.Secret, name string) *corev1.Secret {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: openshiftConfigNamespace,
+		},
+		Type: hubSecret.Type,
+		Data: map[string][]byte{},
+	}
+	for k, v := range hubSecret.Data {
+		secret.Data[k] = v
+	}
+	for k, v := range hubSecret.StringData {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+// newDeliveredConfigMap returns the copy of a hub ConfigMap to deliver in the openshift-config namespace
+// of the managed cluster, only the data is copied.
+func newDeliveredConfigMap(hubConfigMap *corev1.ConfigMap, name string) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: openshiftConfigNamespace,
+		},
+	}
+	if len(hubConfigMap.Data) != 0 {
+		configMap.Data = map[string]string{}
+		for k, v := range hubConfigMap.Data {
+			configMap.Data[k] = v
+		}
+	}
+	if len(hubConfigMap.BinaryData) != 0 {
+		configMap.BinaryData = map[string][]byte{}
+		for k, v := range hubConfigMap.BinaryData {
+			configMap.BinaryData[k] = v
+		}
+	}
+	return configMap
+}
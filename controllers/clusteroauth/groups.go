@@ -0,0 +1,71 @@
+// Copyright Red Hat
+
+package clusteroauth
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	userv1 "github.com/openshift/api/user/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+// buildGroups returns the Groups to deliver to the cluster from the group ConfigMaps of the cluster namespace.
+// Each ConfigMap holds the groups of an AuthRealm, the Groups are named with the AuthRealm as prefix
+// so an AuthRealm can't deliver the Groups of another AuthRealm nor Groups the operator didn't create.
+// The ConfigMaps not generated by the operator for an AuthRealm are ignored.
+// The Groups are sorted so the ManifestWork only changes with the groups, the groups removed from the ConfigMaps
+// are pruned from the cluster as they are no longer in the ManifestWork.
+func buildGroups(ctx context.Context, c client.Client, namespace string) ([]runtime.Object, error) {
+	cms := &corev1.ConfigMapList{}
+	if err := c.List(ctx, cms, client.InNamespace(namespace),
+		client.HasLabels{helpers.GroupSyncLabel, helpers.AuthRealmNameLabel, helpers.AuthRealmNamespaceLabel},
+		client.MatchingLabels{helpers.GeneratedByLabel: helpers.ManagedByValue}); err != nil {
+		return nil, err
+	}
+	groups := make([]*userv1.Group, 0)
+	for i := range cms.Items {
+		authrealm := &identitatemv1alpha1.AuthRealm{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cms.Items[i].Labels[helpers.AuthRealmNameLabel],
+				Namespace: cms.Items[i].Labels[helpers.AuthRealmNamespaceLabel],
+			},
+		}
+		authrealmGroups, err := helpers.ParseGroups(&cms.Items[i])
+		if err != nil {
+			log.Info("Groups not delivered", "namespace", namespace, "error", err.Error())
+			continue
+		}
+		for _, group := range authrealmGroups {
+			labels := helpers.AuthRealmLabels(authrealm)
+			labels[helpers.ManagedByLabel] = helpers.ManagedByValue
+			labels[helpers.GeneratedByLabel] = helpers.ManagedByValue
+			groups = append(groups, &userv1.Group{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: userv1.SchemeGroupVersion.String(),
+					Kind:       "Group",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   helpers.GroupName(authrealm, group.Name),
+					Labels: labels,
+				},
+				Users: userv1.OptionalNames(group.Users),
+			})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	objs := make([]runtime.Object, 0, len(groups))
+	for _, group := range groups {
+		objs = append(objs, group)
+	}
+	return objs, nil
+}
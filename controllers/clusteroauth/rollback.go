@@ -0,0 +1,374 @@
+// Copyright Red Hat
+
+package clusteroauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+	"github.com/identitatem/idp-strategy-operator/pkg/manifestwork"
+	manifestworkv1 "open-cluster-management.io/api/work/v1"
+)
+
+const (
+	// BackplaneManifestWorkName is the name of the ManifestWork delivering the OAuth to the managed cluster
+	BackplaneManifestWorkName string = "idp-backplane"
+
+	// ManifestsRevisionAnnotation is set on the ManifestWork with the revision of its manifests,
+	// the revision is the hash of the manifests.
+	ManifestsRevisionAnnotation string = manifestwork.HashAnnotation
+	// ManifestsAppliedAtAnnotation is set on the ManifestWork when its manifests are updated
+	ManifestsAppliedAtAnnotation string = "identityconfig.identitatem.io/manifests-applied-at"
+	// RolledBackRevisionAnnotation is set on the ManifestWork with the failed revision it was rolled back from.
+	// The failed revision is not applied again until the ClusterOAuths of the cluster change.
+	RolledBackRevisionAnnotation string = "identityconfig.identitatem.io/rolled-back-revision"
+
+	// ManifestWorkLabel is set on the ControllerRevisions with the name of the ManifestWork
+	ManifestWorkLabel string = "identityconfig.identitatem.io/manifestwork"
+	// KnownGoodAnnotation is set on the ControllerRevisions which were available on the managed cluster
+	KnownGoodAnnotation string = "identityconfig.identitatem.io/known-good"
+
+	// RolledBackCondition is set on the ClusterOAuths when the ManifestWork was rolled back
+	RolledBackCondition string = "RolledBack"
+
+	// revisionHistoryLimit is the number of ControllerRevisions kept per managed cluster
+	revisionHistoryLimit = 10
+)
+
+func controllerRevisionName(revision string) string {
+	return fmt.Sprintf("%s-%s", BackplaneManifestWorkName, revision)
+}
+
+// listControllerRevisions returns the revision history of the cluster, oldest first
+func listControllerRevisions(ctx context.Context, c client.Client, namespace string) ([]appsv1.ControllerRevision, error) {
+	crs := &appsv1.ControllerRevisionList{}
+	if err := c.List(ctx, crs,
+		client.InNamespace(namespace),
+		client.MatchingLabels{ManifestWorkLabel: BackplaneManifestWorkName}); err != nil {
+		return nil, err
+	}
+	sort.Slice(crs.Items, func(i, j int) bool {
+		return crs.Items[i].Revision < crs.Items[j].Revision
+	})
+	return crs.Items, nil
+}
+
+// recordControllerRevision adds the manifests to the revision history of the cluster
+// and prunes the oldest revisions, the last known-good revision is always kept.
+func recordControllerRevision(ctx context.Context, c client.Client, namespace, revision string, manifests []manifestworkv1.Manifest) error {
+	crs, err := listControllerRevisions(ctx, c, namespace)
+	if err != nil {
+		return err
+	}
+	for _, cr := range crs {
+		if cr.Name == controllerRevisionName(revision) {
+			return nil
+		}
+	}
+	data, err := controllerRevisionData(manifests)
+	if err != nil {
+		return err
+	}
+	number := int64(1)
+	if len(crs) > 0 {
+		number = crs[len(crs)-1].Revision + 1
+	}
+	cr := newControllerRevision(namespace, revision, data, number, false)
+	if err := helpers.Apply(ctx, c, cr, helpers.ClusterOAuthFieldManager); err != nil {
+		return err
+	}
+
+	crs = append(crs, *cr)
+	lastKnownGood := ""
+	for _, cr := range crs {
+		if cr.GetAnnotations()[KnownGoodAnnotation] == "true" {
+			lastKnownGood = cr.Name
+		}
+	}
+	for i := 0; i < len(crs)-revisionHistoryLimit; i++ {
+		if crs[i].Name == lastKnownGood {
+			continue
+		}
+		if err := c.Delete(ctx, &crs[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// controllerRevisionData returns the manifests stored in a ControllerRevision. The payloads of the
+// Secrets are removed as the ControllerRevisions are readable by the view role, they are restored
+// from the hub secrets on rollback.
+func controllerRevisionData(manifests []manifestworkv1.Manifest) ([]byte, error) {
+	redacted := make([]manifestworkv1.Manifest, 0, len(manifests))
+	for _, m := range manifests {
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(m.Raw, &obj); err != nil {
+			return nil, err
+		}
+		if !isSecretManifest(obj) {
+			redacted = append(redacted, m)
+			continue
+		}
+		delete(obj, "data")
+		delete(obj, "stringData")
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		redacted = append(redacted, manifestworkv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+	return json.Marshal(redacted)
+}
+
+func isSecretManifest(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+func manifestKey(obj map[string]interface{}) client.ObjectKey {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	return client.ObjectKey{Name: name, Namespace: namespace}
+}
+
+// restoreSecrets returns the manifests of a ControllerRevision with the payloads of their Secrets
+// taken from the current manifests, built from the current hub secrets.
+// It returns the Secrets which are no longer in the current manifests.
+func restoreSecrets(revisionManifests, currentManifests []manifestworkv1.Manifest) ([]manifestworkv1.Manifest, []string, error) {
+	secrets := make(map[client.ObjectKey]map[string]interface{})
+	for _, m := range currentManifests {
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(m.Raw, &obj); err != nil {
+			return nil, nil, err
+		}
+		if isSecretManifest(obj) {
+			secrets[manifestKey(obj)] = obj
+		}
+	}
+	manifests := make([]manifestworkv1.Manifest, 0, len(revisionManifests))
+	missing := make([]string, 0)
+	for _, m := range revisionManifests {
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(m.Raw, &obj); err != nil {
+			return nil, nil, err
+		}
+		if !isSecretManifest(obj) {
+			manifests = append(manifests, m)
+			continue
+		}
+		current, ok := secrets[manifestKey(obj)]
+		if !ok {
+			missing = append(missing, manifestKey(obj).String())
+			continue
+		}
+		delete(obj, "data")
+		delete(obj, "stringData")
+		for _, field := range []string{"data", "stringData"} {
+			if value, ok := current[field]; ok {
+				obj[field] = value
+			}
+		}
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifests = append(manifests, manifestworkv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+	return manifests, missing, nil
+}
+
+// newControllerRevision returns the ControllerRevision of the manifests of a revision,
+// the whole ControllerRevision is applied each time as the apply removes the fields it no longer sets.
+func newControllerRevision(namespace, revision string, data []byte, number int64, knownGood bool) *appsv1.ControllerRevision {
+	labels := helpers.ClusterAggregateLabels()
+	labels[ManifestWorkLabel] = BackplaneManifestWorkName
+	cr := &appsv1.ControllerRevision{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "ControllerRevision",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controllerRevisionName(revision),
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: number,
+	}
+	if knownGood {
+		cr.Annotations = map[string]string{KnownGoodAnnotation: "true"}
+	}
+	return cr
+}
+
+// deleteControllerRevisions deletes the revision history of the cluster
+func deleteControllerRevisions(ctx context.Context, c client.Client, namespace string) error {
+	crs, err := listControllerRevisions(ctx, c, namespace)
+	if err != nil {
+		return err
+	}
+	for i := range crs {
+		if err := c.Delete(ctx, &crs[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// markKnownGood flags the revision as available on the managed cluster
+func markKnownGood(ctx context.Context, c client.Client, namespace, revision string) error {
+	cr := &appsv1.ControllerRevision{}
+	if err := c.Get(ctx, client.ObjectKey{Name: controllerRevisionName(revision), Namespace: namespace}, cr); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if cr.GetAnnotations()[KnownGoodAnnotation] == "true" {
+		return nil
+	}
+	manifests := []manifestworkv1.Manifest{}
+	if err := json.Unmarshal(cr.Data.Raw, &manifests); err != nil {
+		return err
+	}
+	data, err := controllerRevisionData(manifests)
+	if err != nil {
+		return err
+	}
+	return helpers.Apply(ctx, c,
+		newControllerRevision(namespace, revision, data, cr.Revision, true),
+		helpers.ClusterOAuthFieldManager)
+}
+
+// getLastKnownGood returns the most recent known-good revision other than the given one, nil if none
+func getLastKnownGood(ctx context.Context, c client.Client, namespace, revision string) (*appsv1.ControllerRevision, error) {
+	crs, err := listControllerRevisions(ctx, c, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(crs) - 1; i >= 0; i-- {
+		if crs[i].Name != controllerRevisionName(revision) &&
+			crs[i].GetAnnotations()[KnownGoodAnnotation] == "true" {
+			return &crs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// checkManifestWork marks the revision of the ManifestWork as known-good once available and
+// rolls the ManifestWork back to the last known-good revision if it fails within the rollback window.
+// The Secrets of the known-good revision are restored from the current manifests.
+// It returns true if the ManifestWork was rolled back.
+func (r *ClusterOAuthReconciler) checkManifestWork(ctx context.Context,
+	mw *manifestworkv1.ManifestWork,
+	currentManifests []manifestworkv1.Manifest) (bool, error) {
+	revision := mw.GetAnnotations()[ManifestsRevisionAnnotation]
+	if len(revision) == 0 || len(mw.GetAnnotations()[RolledBackRevisionAnnotation]) != 0 {
+		return false, nil
+	}
+	appliedAt, err := time.Parse(time.RFC3339, mw.GetAnnotations()[ManifestsAppliedAtAnnotation])
+	if err != nil {
+		appliedAt = mw.CreationTimestamp.Time
+	}
+
+	status := manifestwork.GetStatus(mw, appliedAt)
+	switch {
+	case status.Available:
+		return false, markKnownGood(ctx, r.Client, mw.Namespace, revision)
+	case !status.Failed:
+		return false, nil
+	case r.RollbackWindow == 0 || time.Now().After(appliedAt.Add(r.RollbackWindow)):
+		return false, nil
+	}
+
+	lastKnownGood, err := getLastKnownGood(ctx, r.Client, mw.Namespace, revision)
+	if err != nil {
+		return false, err
+	}
+	if lastKnownGood == nil {
+		r.Log.Info("No known-good revision to roll back to", "namespace", mw.Namespace, "revision", revision)
+		return false, nil
+	}
+	manifests := []manifestworkv1.Manifest{}
+	if err := json.Unmarshal(lastKnownGood.Data.Raw, &manifests); err != nil {
+		return false, err
+	}
+	manifests, missing, err := restoreSecrets(manifests, currentManifests)
+	if err != nil {
+		return false, err
+	}
+	if len(missing) != 0 {
+		r.Log.Info("The secrets of the known-good revision no longer exist on the hub, not rolling back",
+			"namespace", mw.Namespace, "revision", revision, "secrets", missing)
+		return false, nil
+	}
+	rolledBackManifestWork, err := newManifestWorkBuilder(r.Scheme, mw.Namespace).
+		AddManifests(manifests...).
+		WithAnnotation(ManifestsAppliedAtAnnotation, time.Now().UTC().Format(time.RFC3339)).
+		WithAnnotation(RolledBackRevisionAnnotation, revision).
+		Build()
+	if err != nil {
+		return false, err
+	}
+	goodRevision := rolledBackManifestWork.GetAnnotations()[ManifestsRevisionAnnotation]
+
+	r.Log.Info("Rolling back ManifestWork", "namespace", mw.Namespace, "from", revision, "to", goodRevision)
+	if _, err := manifestwork.Apply(ctx, r.Client, rolledBackManifestWork, helpers.ClusterOAuthFieldManager); err != nil {
+		return false, err
+	}
+
+	message := fmt.Sprintf("revision %s failed on the managed cluster, rolled back to revision %s", revision, goodRevision)
+	if len(status.Message) != 0 {
+		message = fmt.Sprintf("%s: %s", message, status.Message)
+	}
+	return true, r.setRolledBackCondition(ctx, mw.Namespace, metav1.ConditionTrue, "ManifestWorkFailed", message)
+}
+
+// setRolledBackCondition reports the rollback on all ClusterOAuths of the cluster
+func (r *ClusterOAuthReconciler) setRolledBackCondition(ctx context.Context, namespace string,
+	status metav1.ConditionStatus,
+	reason, message string) error {
+	clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+	if err := r.Client.List(ctx, clusterOAuths, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	for i := range clusterOAuths.Items {
+		clusterOAuth := &clusterOAuths.Items[i]
+		if status == metav1.ConditionFalse &&
+			!meta.IsStatusConditionTrue(clusterOAuth.Status.Conditions, RolledBackCondition) {
+			continue
+		}
+		conditions := append([]metav1.Condition{}, clusterOAuth.Status.Conditions...)
+		meta.SetStatusCondition(&clusterOAuth.Status.Conditions, metav1.Condition{
+			Type:    RolledBackCondition,
+			Status:  status,
+			Reason:  reason,
+			Message: message,
+		})
+		if equality.Semantic.DeepEqual(conditions, clusterOAuth.Status.Conditions) {
+			continue
+		}
+		if err := r.Client.Status().Update(ctx, clusterOAuth); err != nil {
+			return err
+		}
+		if r.Recorder != nil && status == metav1.ConditionTrue {
+			r.Recorder.Event(clusterOAuth, corev1.EventTypeWarning, "RolledBack", message)
+		}
+	}
+	return nil
+}
@@ -6,16 +6,23 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -28,6 +35,8 @@ import (
 	idpclientset "github.com/identitatem/idp-client-api/api/client/clientset/versioned"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 	idpconfig "github.com/identitatem/idp-client-api/config"
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+	"github.com/identitatem/idp-strategy-operator/pkg/manifestwork"
 	openshiftconfigv1 "github.com/openshift/api/config/v1"
 	clientsetcluster "open-cluster-management.io/api/client/cluster/clientset/versioned"
 	clientsetwork "open-cluster-management.io/api/client/work/clientset/versioned"
@@ -224,7 +233,7 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 				Scheme: scheme.Scheme,
 			}
 			req := ctrl.Request{}
-			req.Name = ClusterOAuthName1
+			req.Name = BackplaneManifestWorkName
 			req.Namespace = ClusterName
 			_, err := r.Reconcile(context.TODO(), req)
 			Expect(err).To(BeNil())
@@ -239,6 +248,21 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 			// should find manifest for OAuth and manifest for Secret
 			Expect(len(mw.Spec.Workload.Manifests)).To(Equal(2))
 			//manifest := mw.Spec.Workload.Manifests[0]
+
+			secret := &corev1.Secret{}
+			err = yaml.Unmarshal(mw.Spec.Workload.Manifests[0].Raw, secret)
+			Expect(err).To(BeNil())
+			Expect(secret.Kind).To(Equal("Secret"))
+			Expect(secret.Namespace).To(Equal("openshift-config"))
+			Expect(secret.Name).To(Equal(deliveredName(MyIDPName1, "")))
+			Expect(secret.ResourceVersion).To(BeEmpty())
+			Expect(secret.UID).To(BeEmpty())
+			Expect(secret.ManagedFields).To(BeEmpty())
+
+			oauth := &openshiftconfigv1.OAuth{}
+			err = yaml.Unmarshal(mw.Spec.Workload.Manifests[1].Raw, oauth)
+			Expect(err).To(BeNil())
+			Expect(oauth.Spec.IdentityProviders[0].GitHub.ClientSecret.Name).To(Equal(secret.Name))
 		})
 
 		By("Calling reconcile 2nd time", func() {
@@ -248,7 +272,7 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 				Scheme: scheme.Scheme,
 			}
 			req := ctrl.Request{}
-			req.Name = ClusterOAuthName1
+			req.Name = BackplaneManifestWorkName
 			req.Namespace = ClusterName
 			_, err := r.Reconcile(context.TODO(), req)
 			Expect(err).To(BeNil())
@@ -273,7 +297,7 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 
 		var secret3 *corev1.Secret
 		By(fmt.Sprintf("creation of IDP secret 3 in cluster namespace %s", ClusterName), func() {
-			secret2 = &corev1.Secret{
+			secret3 = &corev1.Secret{
 				TypeMeta: metav1.TypeMeta{
 					APIVersion: corev1.SchemeGroupVersion.String(),
 					Kind:       "Secret",
@@ -297,6 +321,9 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      ClusterOAuthName2,
 					Namespace: ClusterName,
+					Annotations: map[string]string{
+						RolloutRevisionAnnotation: "revision-2",
+					},
 				},
 				Spec: identitatemv1alpha1.ClusterOAuthSpec{
 					OAuth: &openshiftconfigv1.OAuth{
@@ -349,7 +376,7 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 				Scheme: scheme.Scheme,
 			}
 			req := ctrl.Request{}
-			req.Name = ClusterOAuthName2
+			req.Name = BackplaneManifestWorkName
 			req.Namespace = ClusterName
 			_, err := r.Reconcile(context.TODO(), req)
 			Expect(err).To(BeNil())
@@ -361,10 +388,812 @@ var _ = Describe("Process clusterOAuth for Strategy backplane: ", func() {
 			//var mw *workv1.ManifestWork
 			//mw, err := clientSetWork.WorkV1().ManifestWorks(ClusterName).Get(context.TODO(), "idp-backplane", metav1.GetOptions{})
 			Expect(err).To(BeNil())
-			// should find manifest for OAuth and manifest for Secret
+			// should find manifest for OAuth and manifests for the 3 Secrets
+			Expect(len(mw.Spec.Workload.Manifests)).To(Equal(4))
+
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: ClusterOAuthName2, Namespace: ClusterName}, clusterOAuth)
+			Expect(err).To(BeNil())
+			Expect(clusterOAuth.GetAnnotations()).To(HaveKeyWithValue(DeliveredRolloutRevisionAnnotation, "revision-2"))
+			Expect(clusterOAuth.GetAnnotations()).To(HaveKeyWithValue(DeliveredManifestsRevisionAnnotation,
+				mw.GetAnnotations()[ManifestsRevisionAnnotation]))
+		})
+
+	})
+})
+
+var _ = Describe("Roll back the ManifestWork of a failed ClusterOAuth: ", func() {
+	ClusterOAuthName := "my-authrealm-backplane-rollback"
+	ClusterName := "my-cluster-rollback"
+	MyIDPName := "my-idp-rollback"
+
+	reconcileClusterOAuth := func() {
+		r := &ClusterOAuthReconciler{
+			Client:         k8sClient,
+			Log:            logf.Log,
+			Scheme:         scheme.Scheme,
+			RollbackWindow: 10 * time.Minute,
+		}
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	setManifestWorkCondition := func(conditionType string, status metav1.ConditionStatus) {
+		mw := &workv1.ManifestWork{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+		Expect(err).To(BeNil())
+		meta.SetStatusCondition(&mw.Status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             status,
+			Reason:             "Test",
+			ObservedGeneration: mw.Generation,
+		})
+		err = k8sClient.Status().Update(context.TODO(), mw)
+		Expect(err).To(BeNil())
+	}
+
+	It("rolls back to the last known-good revision", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ClusterOAuthName,
+				Namespace: ClusterName,
+			},
+			Spec: identitatemv1alpha1.ClusterOAuthSpec{
+				OAuth: &openshiftconfigv1.OAuth{
+					Spec: openshiftconfigv1.OAuthSpec{
+						IdentityProviders: []openshiftconfigv1.IdentityProvider{
+							{
+								Name:          MyIDPName,
+								MappingMethod: openshiftconfigv1.MappingMethodClaim,
+								IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+									Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+									GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+										ClientID: "good",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		By(fmt.Sprintf("creation of ClusterOAuth for managed cluster %s", ClusterName), func() {
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		var goodRevision string
+		By("Delivering the first revision and marking it available", func() {
+			reconcileClusterOAuth()
+			mw := &workv1.ManifestWork{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			goodRevision = mw.GetAnnotations()[ManifestsRevisionAnnotation]
+			Expect(goodRevision).ToNot(BeEmpty())
+
+			setManifestWorkCondition(workv1.WorkAvailable, metav1.ConditionTrue)
+			reconcileClusterOAuth()
+
+			cr := &appsv1.ControllerRevision{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: controllerRevisionName(goodRevision), Namespace: ClusterName}, cr)
+			Expect(err).To(BeNil())
+			Expect(cr.GetAnnotations()[KnownGoodAnnotation]).To(Equal("true"))
+		})
+
+		var badRevision string
+		By("Delivering a second revision", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(clusterOAuth), clusterOAuth)
+			Expect(err).To(BeNil())
+			clusterOAuth.Spec.OAuth.Spec.IdentityProviders[0].GitHub.ClientID = "bad"
+			err = k8sClient.Update(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+			reconcileClusterOAuth()
+
+			mw := &workv1.ManifestWork{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			badRevision = mw.GetAnnotations()[ManifestsRevisionAnnotation]
+			Expect(badRevision).ToNot(Equal(goodRevision))
+		})
+
+		By("Failing the second revision on the managed cluster", func() {
+			setManifestWorkCondition(workv1.WorkDegraded, metav1.ConditionTrue)
+			reconcileClusterOAuth()
+		})
+
+		By("Checking the ManifestWork is rolled back", func() {
+			mw := &workv1.ManifestWork{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			Expect(mw.GetAnnotations()[ManifestsRevisionAnnotation]).To(Equal(goodRevision))
+			Expect(mw.GetAnnotations()[RolledBackRevisionAnnotation]).To(Equal(badRevision))
+			Expect(mw.GetLabels()[helpers.ManagedByLabel]).To(Equal(helpers.ManagedByValue))
+			Expect(helpers.IsClusterAggregate(mw)).To(BeTrue())
+
+			err = k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(clusterOAuth), clusterOAuth)
+			Expect(err).To(BeNil())
+			Expect(meta.IsStatusConditionTrue(clusterOAuth.Status.Conditions, RolledBackCondition)).To(BeTrue())
+		})
+
+		By("Checking the failed revision is not applied again", func() {
+			reconcileClusterOAuth()
+			mw := &workv1.ManifestWork{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			Expect(mw.GetAnnotations()[ManifestsRevisionAnnotation]).To(Equal(goodRevision))
+			Expect(mw.GetLabels()[manifestwork.OwnerLabel]).To(Equal(helpers.ClusterOAuthFieldManager))
+		})
+
+		By("Deleting the ManifestWork once the cluster has no ClusterOAuth", func() {
+			err := k8sClient.Delete(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+			reconcileClusterOAuth()
+			mw := &workv1.ManifestWork{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Deliver the break-glass user: ", func() {
+	ClusterOAuthName := "my-authrealm-backplane-break-glass"
+	ClusterName := "my-cluster-break-glass"
+
+	reconcileClusterOAuth := func() {
+		r := &ClusterOAuthReconciler{
+			Client:     k8sClient,
+			Log:        logf.Log,
+			Scheme:     scheme.Scheme,
+			BreakGlass: true,
+		}
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	It("adds the break-glass identity provider and rotates its password", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+
+		By(fmt.Sprintf("creation of ClusterOAuth for managed cluster %s", ClusterName), func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterOAuthName,
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		var password []byte
+		By("Checking the break-glass secret and manifestwork", func() {
+			reconcileClusterOAuth()
+			secret := &corev1.Secret{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+			password = secret.Data["password"]
+			Expect(password).ToNot(BeEmpty())
+			Expect(string(secret.Data["htpasswd"])).To(HavePrefix(BreakGlassUsername + ":"))
+
+			mw := &workv1.ManifestWork{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			// should find manifests for the htpasswd Secret, the ClusterRoleBinding and the OAuth
 			Expect(len(mw.Spec.Workload.Manifests)).To(Equal(3))
+			for _, manifest := range mw.Spec.Workload.Manifests {
+				Expect(string(manifest.Raw)).ToNot(ContainSubstring(`"password"`))
+			}
+			Expect(string(mw.Spec.Workload.Manifests[2].Raw)).To(ContainSubstring(BreakGlassIdentityProviderName))
+
+			//The revision history doesn't keep the htpasswd
+			cr := &appsv1.ControllerRevision{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{
+				Name:      controllerRevisionName(mw.GetAnnotations()[ManifestsRevisionAnnotation]),
+				Namespace: ClusterName,
+			}, cr)
+			Expect(err).To(BeNil())
+			Expect(string(cr.Data.Raw)).To(ContainSubstring(BreakGlassSecretName))
+			Expect(string(cr.Data.Raw)).ToNot(ContainSubstring(`"htpasswd"`))
+		})
+
+		By("Rotating the break-glass password", func() {
+			secret := &corev1.Secret{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+			secret.Annotations = map[string]string{BreakGlassRotateAnnotation: "true"}
+			err = k8sClient.Update(context.TODO(), secret)
+			Expect(err).To(BeNil())
+
+			reconcileClusterOAuth()
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+			Expect(secret.Data["password"]).ToNot(Equal(password))
+			Expect(secret.GetAnnotations()[BreakGlassRotateAnnotation]).To(Equal("false"))
+			rotatedPassword := secret.Data["password"]
+
+			reconcileClusterOAuth()
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+			Expect(secret.Data["password"]).To(Equal(rotatedPassword))
+			Expect(secret.GetLabels()[helpers.ManagedByLabel]).To(Equal(helpers.ManagedByValue))
+			Expect(helpers.IsClusterAggregate(secret)).To(BeTrue())
+		})
+
+		By("Deleting the last ClusterOAuth of the cluster", func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: ClusterOAuthName, Namespace: ClusterName}, clusterOAuth)
+			Expect(err).To(BeNil())
+			err = k8sClient.Delete(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+			reconcileClusterOAuth()
+		})
+
+		By("Checking the break-glass secret and the revision history are deleted", func() {
+			secret := &corev1.Secret{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			crs := &appsv1.ControllerRevisionList{}
+			err = k8sClient.List(context.TODO(), crs, client.InNamespace(ClusterName))
+			Expect(err).To(BeNil())
+			Expect(crs.Items).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Leave the break-glass secrets not managed by the operator: ", func() {
+	ClusterOAuthName := "my-authrealm-backplane-foreign-break-glass"
+	ClusterName := "my-cluster-foreign-break-glass"
+
+	reconcileClusterOAuth := func(breakGlass bool) {
+		r := &ClusterOAuthReconciler{
+			Client:     k8sClient,
+			Log:        logf.Log,
+			Scheme:     scheme.Scheme,
+			BreakGlass: breakGlass,
+		}
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	It("neither delivers nor deletes the secret", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+
+		By("creation of a break-glass secret without the operator labels", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      BreakGlassSecretName,
+					Namespace: ClusterName,
+				},
+				Data: map[string][]byte{"htpasswd": []byte("someone:hash")},
+			}
+			err := k8sClient.Create(context.TODO(), secret)
+			Expect(err).To(BeNil())
+		})
+
+		By(fmt.Sprintf("creation of ClusterOAuth for managed cluster %s", ClusterName), func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterOAuthName,
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		By("Checking the secret is not delivered", func() {
+			reconcileClusterOAuth(true)
+			mw := &workv1.ManifestWork{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			for _, manifest := range mw.Spec.Workload.Manifests {
+				Expect(string(manifest.Raw)).ToNot(ContainSubstring(BreakGlassIdentityProviderName))
+			}
+			secret := &corev1.Secret{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+			Expect(string(secret.Data["htpasswd"])).To(Equal("someone:hash"))
+		})
+
+		By("Checking the secret is not deleted", func() {
+			reconcileClusterOAuth(false)
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: ClusterOAuthName, Namespace: ClusterName}, clusterOAuth)
+			Expect(err).To(BeNil())
+			err = k8sClient.Delete(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+			reconcileClusterOAuth(false)
+
+			secret := &corev1.Secret{}
+			err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: BreakGlassSecretName, Namespace: ClusterName}, secret)
+			Expect(err).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Deliver the CA ConfigMaps: ", func() {
+	ClusterOAuthName := "my-authrealm-backplane-ca"
+	ClusterName := "my-cluster-ca"
+	MyIDPName := "my-idp-ca"
+	MyCAName := "my-ca"
+
+	r := &ClusterOAuthReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	reconcileClusterOAuth := func() {
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	getDeliveredConfigMap := func() *corev1.ConfigMap {
+		mw := &workv1.ManifestWork{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+		Expect(err).To(BeNil())
+		// should find manifests for the ConfigMap and the OAuth
+		Expect(len(mw.Spec.Workload.Manifests)).To(Equal(2))
+		configMap := &corev1.ConfigMap{}
+		err = yaml.Unmarshal(mw.Spec.Workload.Manifests[0].Raw, configMap)
+		Expect(err).To(BeNil())
+		return configMap
+	}
+
+	It("delivers the CA of the identity providers and its changes", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MyCAName,
+				Namespace: ClusterName,
+			},
+			Data: map[string]string{
+				"ca.crt": "ca-1",
+			},
+		}
+		By(fmt.Sprintf("creation of the CA ConfigMap in cluster namespace %s", ClusterName), func() {
+			err := k8sClient.Create(context.TODO(), configMap)
+			Expect(err).To(BeNil())
+		})
+
+		By(fmt.Sprintf("creation of ClusterOAuth for managed cluster %s", ClusterName), func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterOAuthName,
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{
+						Spec: openshiftconfigv1.OAuthSpec{
+							IdentityProviders: []openshiftconfigv1.IdentityProvider{
+								{
+									Name:          MyIDPName,
+									MappingMethod: openshiftconfigv1.MappingMethodClaim,
+									IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+										Type: openshiftconfigv1.IdentityProviderTypeOpenID,
+										OpenID: &openshiftconfigv1.OpenIDIdentityProvider{
+											ClientID: "me",
+											Issuer:   "https://dex.example.com",
+											CA: openshiftconfigv1.ConfigMapNameReference{
+												Name: MyCAName,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		By("Checking the CA ConfigMap is delivered in openshift-config", func() {
+			reconcileClusterOAuth()
+			delivered := getDeliveredConfigMap()
+			Expect(delivered.Namespace).To(Equal("openshift-config"))
+			Expect(delivered.Name).To(Equal(deliveredName(MyIDPName, "-ca")))
+			Expect(delivered.Data["ca.crt"]).To(Equal("ca-1"))
+
+			mw := &workv1.ManifestWork{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+			Expect(err).To(BeNil())
+			oauth := &openshiftconfigv1.OAuth{}
+			err = yaml.Unmarshal(mw.Spec.Workload.Manifests[1].Raw, oauth)
+			Expect(err).To(BeNil())
+			Expect(oauth.Spec.IdentityProviders[0].OpenID.CA.Name).To(Equal(delivered.Name))
+		})
+
+		By("Updating the CA ConfigMap on the hub", func() {
+			Expect(r.referenceRequest("ConfigMap")(configMap)).To(HaveLen(1))
+			Expect(r.referenceRequest("ConfigMap")(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: ClusterName},
+			})).To(BeEmpty())
+
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(configMap), configMap)
+			Expect(err).To(BeNil())
+			configMap.Data["ca.crt"] = "ca-2"
+			err = k8sClient.Update(context.TODO(), configMap)
+			Expect(err).To(BeNil())
+
+			reconcileClusterOAuth()
+			Expect(getDeliveredConfigMap().Data["ca.crt"]).To(Equal("ca-2"))
+		})
+	})
+})
+
+var _ = Describe("Deliver the references of the identity providers: ", func() {
+	ClusterOAuthName := "my-authrealm-backplane-references"
+	ClusterName := "my-cluster-references"
+	MyLDAPName := "my-idp-ldap"
+	MyHTPasswdName := "my-idp-htpasswd"
+
+	r := &ClusterOAuthReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	reconcileClusterOAuth := func() {
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	getManifests := func() (map[string]string, *openshiftconfigv1.OAuth) {
+		mw := &workv1.ManifestWork{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+		Expect(err).To(BeNil())
+		kinds := map[string]string{}
+		oauth := &openshiftconfigv1.OAuth{}
+		for _, manifest := range mw.Spec.Workload.Manifests {
+			u := &unstructured.Unstructured{}
+			err := u.UnmarshalJSON(manifest.Raw)
+			Expect(err).To(BeNil())
+			if u.GetKind() == "OAuth" {
+				err := yaml.Unmarshal(manifest.Raw, oauth)
+				Expect(err).To(BeNil())
+				continue
+			}
+			Expect(u.GetNamespace()).To(Equal("openshift-config"))
+			kinds[u.GetName()] = u.GetKind()
+		}
+		return kinds, oauth
+	}
+
+	getCondition := func() *metav1.Condition {
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: ClusterOAuthName, Namespace: ClusterName}, clusterOAuth)
+		Expect(err).To(BeNil())
+		return meta.FindStatusCondition(clusterOAuth.Status.Conditions, ReferencesResolvedCondition)
+	}
+
+	It("delivers the referenced objects and reports the missing ones", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+
+		By("creation of the LDAP bind password and CA", func() {
+			err := k8sClient.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "ldap-bind", Namespace: ClusterName},
+				StringData: map[string]string{"bindPassword": "secret"},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "ldap-ca", Namespace: ClusterName},
+				Data:       map[string]string{"ca.crt": "ca"},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		By(fmt.Sprintf("creation of ClusterOAuth for managed cluster %s", ClusterName), func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterOAuthName,
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{
+						Spec: openshiftconfigv1.OAuthSpec{
+							IdentityProviders: []openshiftconfigv1.IdentityProvider{
+								{
+									Name:          MyLDAPName,
+									MappingMethod: openshiftconfigv1.MappingMethodClaim,
+									IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+										Type: openshiftconfigv1.IdentityProviderTypeLDAP,
+										LDAP: &openshiftconfigv1.LDAPIdentityProvider{
+											URL:          "ldaps://ldap.example.com/ou=users,dc=example,dc=com?uid",
+											BindDN:       "cn=admin",
+											BindPassword: openshiftconfigv1.SecretNameReference{Name: "ldap-bind"},
+											CA:           openshiftconfigv1.ConfigMapNameReference{Name: "ldap-ca"},
+										},
+									},
+								},
+								{
+									Name:          MyHTPasswdName,
+									MappingMethod: openshiftconfigv1.MappingMethodClaim,
+									IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+										Type: openshiftconfigv1.IdentityProviderTypeHTPasswd,
+										HTPasswd: &openshiftconfigv1.HTPasswdIdentityProvider{
+											FileData: openshiftconfigv1.SecretNameReference{Name: "htpasswd"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		By("Checking the identity provider with a missing reference is reported and not delivered", func() {
+			reconcileClusterOAuth()
+			kinds, oauth := getManifests()
+			Expect(kinds).To(Equal(map[string]string{
+				deliveredName(MyLDAPName, "-bind-password"): "Secret",
+				deliveredName(MyLDAPName, "-ca"):            "ConfigMap",
+			}))
+			Expect(oauth.Spec.IdentityProviders).To(HaveLen(1))
+			Expect(oauth.Spec.IdentityProviders[0].LDAP.BindPassword.Name).To(Equal(deliveredName(MyLDAPName, "-bind-password")))
+			Expect(oauth.Spec.IdentityProviders[0].LDAP.CA.Name).To(Equal(deliveredName(MyLDAPName, "-ca")))
+
+			condition := getCondition()
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring(MyHTPasswdName))
+			Expect(condition.Message).To(ContainSubstring("htpasswd.fileData"))
 		})
 
+		By("Creating the missing secret", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "htpasswd", Namespace: ClusterName},
+				StringData: map[string]string{"htpasswd": "user:hash"},
+			}
+			Expect(r.referenceRequest("Secret")(secret)).To(HaveLen(1))
+			err := k8sClient.Create(context.TODO(), secret)
+			Expect(err).To(BeNil())
+
+			reconcileClusterOAuth()
+			kinds, oauth := getManifests()
+			Expect(kinds).To(HaveKeyWithValue(deliveredName(MyHTPasswdName, ""), "Secret"))
+			Expect(oauth.Spec.IdentityProviders).To(HaveLen(2))
+			Expect(oauth.Spec.IdentityProviders[1].HTPasswd.FileData.Name).To(Equal(deliveredName(MyHTPasswdName, "")))
+			Expect(getCondition().Status).To(Equal(metav1.ConditionTrue))
+		})
+
+		By("Reporting the identity providers whose name is already used", func() {
+			htpasswd := openshiftconfigv1.IdentityProviderConfig{
+				Type: openshiftconfigv1.IdentityProviderTypeHTPasswd,
+				HTPasswd: &openshiftconfigv1.HTPasswdIdentityProvider{
+					FileData: openshiftconfigv1.SecretNameReference{Name: "htpasswd"},
+				},
+			}
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterOAuthName + "-2",
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{
+						Spec: openshiftconfigv1.OAuthSpec{
+							IdentityProviders: []openshiftconfigv1.IdentityProvider{
+								{Name: MyHTPasswdName, IdentityProviderConfig: htpasswd},
+								{Name: BreakGlassIdentityProviderName, IdentityProviderConfig: htpasswd},
+							},
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+
+			reconcileClusterOAuth()
+			_, oauth := getManifests()
+			Expect(oauth.Spec.IdentityProviders).To(HaveLen(2))
+			Expect(getCondition().Status).To(Equal(metav1.ConditionTrue))
+
+			err = k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(clusterOAuth), clusterOAuth)
+			Expect(err).To(BeNil())
+			condition := meta.FindStatusCondition(clusterOAuth.Status.Conditions, ReferencesResolvedCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("NameCollision"))
+			Expect(condition.Message).To(ContainSubstring(ClusterOAuthName))
+			Expect(condition.Message).To(ContainSubstring(BreakGlassIdentityProviderName))
+		})
+	})
+
+	It("delivers the references under valid names whatever the identity provider name", func() {
+		for _, idpName := range []string{"My IdP", "idp_with:colons", strings.Repeat("long-idp-name", 30)} {
+			for _, suffix := range []string{"", "-ca", "-bind-password"} {
+				name := deliveredName(idpName, suffix)
+				Expect(validation.IsDNS1123Subdomain(name)).To(BeEmpty(), name)
+			}
+		}
+		Expect(deliveredName("My IdP", "-ca")).To(HavePrefix("idp-my-idp-ca-"))
+		Expect(deliveredName("My IdP", "")).ToNot(Equal(deliveredName("my-idp", "")))
+		Expect(deliveredName("idp-ca", "")).ToNot(Equal(deliveredName("idp", "-ca")))
+	})
+})
+
+var _ = Describe("Deliver the groups: ", func() {
+	ClusterName := "my-cluster-groups"
+
+	r := &ClusterOAuthReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	reconcileClusterOAuth := func() {
+		req := ctrl.Request{}
+		req.Name = BackplaneManifestWorkName
+		req.Namespace = ClusterName
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+
+	getGroups := func() map[string][]string {
+		mw := &workv1.ManifestWork{}
+		err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: BackplaneManifestWorkName, Namespace: ClusterName}, mw)
+		Expect(err).To(BeNil())
+		groups := map[string][]string{}
+		for _, manifest := range mw.Spec.Workload.Manifests {
+			u := &unstructured.Unstructured{}
+			err := u.UnmarshalJSON(manifest.Raw)
+			Expect(err).To(BeNil())
+			if u.GetKind() != "Group" {
+				continue
+			}
+			Expect(u.GetLabels()).To(HaveKeyWithValue(helpers.GeneratedByLabel, helpers.ManagedByValue))
+			users, _, err := unstructured.NestedStringSlice(u.Object, "users")
+			Expect(err).To(BeNil())
+			groups[u.GetName()] = users
+		}
+		return groups
+	}
+
+	authRealm1 := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "authrealm-1", Namespace: "authrealmns"},
+	}
+	authRealm2 := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "authrealm-2", Namespace: "authrealmns"},
+	}
+	newGroupsConfigMap := func(authRealm *identitatemv1alpha1.AuthRealm, groups string) *corev1.ConfigMap {
+		labels := helpers.OwnerLabels(authRealm, "", "")
+		labels[helpers.GroupSyncLabel] = ""
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      helpers.GroupsConfigMapName(authRealm),
+				Namespace: ClusterName,
+				Labels:    labels,
+			},
+			Data: map[string]string{helpers.GroupsKey: groups},
+		}
+	}
+
+	It("prefixes the groups with their AuthRealm and prunes the removed ones", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s and its ClusterOAuth", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-authrealm-backplane-groups",
+					Namespace: ClusterName,
+				},
+				Spec: identitatemv1alpha1.ClusterOAuthSpec{
+					OAuth: &openshiftconfigv1.OAuth{},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), clusterOAuth)
+			Expect(err).To(BeNil())
+		})
+
+		By("creation of the groups of 2 AuthRealms and of a ConfigMap not generated by the operator", func() {
+			err := k8sClient.Create(context.TODO(), newGroupsConfigMap(authRealm1,
+				"- name: admins\n  users: [alice, bob]\n- name: \"team a:developers\"\n  users: [carol]\n"))
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), newGroupsConfigMap(authRealm2,
+				"- name: admins\n  users: [\" dave \", alice]\n"))
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "not-generated-groups",
+					Namespace: ClusterName,
+					Labels:    map[string]string{helpers.GroupSyncLabel: ""},
+				},
+				Data: map[string]string{helpers.GroupsKey: "- name: cluster-admins\n  users: [mallory]\n"},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		By("Checking the groups are delivered with the AuthRealm prefix", func() {
+			reconcileClusterOAuth()
+			Expect(getGroups()).To(Equal(map[string][]string{
+				helpers.GroupName(authRealm1, "admins"):            {"alice", "bob"},
+				helpers.GroupName(authRealm1, "team a:developers"): {"carol"},
+				helpers.GroupName(authRealm2, "admins"):            {"alice", "dave"},
+			}))
+		})
+
+		By("Removing the groups of an AuthRealm upstream", func() {
+			cm := &corev1.ConfigMap{}
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: helpers.GroupsConfigMapName(authRealm1), Namespace: ClusterName}, cm)
+			Expect(err).To(BeNil())
+			cm.Data = map[string]string{helpers.GroupsKey: "[]"}
+			err = k8sClient.Update(context.TODO(), cm)
+			Expect(err).To(BeNil())
+
+			reconcileClusterOAuth()
+			Expect(getGroups()).To(Equal(map[string][]string{
+				helpers.GroupName(authRealm2, "admins"): {"alice", "dave"},
+			}))
+		})
 	})
 })
 
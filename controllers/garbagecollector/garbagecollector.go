@@ -0,0 +1,166 @@
+// Copyright Red Hat
+
+package garbagecollector
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+// GarbageCollector periodically deletes the generated resources whose AuthRealm or Strategy no longer exists.
+// The generated resources live in other namespaces than their AuthRealm and so can't be deleted
+// by the Kubernetes garbage collector, they are linked to their source by the owner labels.
+type GarbageCollector struct {
+	Client client.Client
+	Log    logr.Logger
+	// Interval is the period between 2 collections
+	Interval time.Duration
+}
+
+//+kubebuilder:rbac:groups="",resources={secrets,configmaps},verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=auth.identitatem.io,resources=dexclients,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={authrealms,strategies},verbs=get;list;watch
+//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=clusteroauths,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=work.open-cluster-management.io,resources=manifestworks,verbs=get;list;watch;delete
+
+// Start runs the collections until the context is done
+func (gc *GarbageCollector) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := gc.Collect(ctx); err != nil {
+			gc.Log.Error(err, "garbage collection failed")
+		}
+	}, gc.Interval)
+	return nil
+}
+
+// NeedLeaderElection runs the garbage collector on the leader only
+func (gc *GarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// SetupWithManager adds the garbage collector to the manager
+func (gc *GarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if err := identitatemv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	if err := identitatemdexv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	if err := workv1.AddToScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	return mgr.Add(gc)
+}
+
+// newGeneratedLists returns the lists of the kinds of the generated resources
+func newGeneratedLists() []client.ObjectList {
+	return []client.ObjectList{
+		&corev1.SecretList{},
+		&corev1.ConfigMapList{},
+		&identitatemdexv1alpha1.DexClientList{},
+		&identitatemv1alpha1.ClusterOAuthList{},
+		&workv1.ManifestWorkList{},
+		&appsv1.ControllerRevisionList{},
+	}
+}
+
+// Collect deletes the orphans among the resources labeled as generated by the operator
+func (gc *GarbageCollector) Collect(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, list := range newGeneratedLists() {
+		if err := gc.Client.List(ctx, list, client.MatchingLabels{helpers.GeneratedByLabel: helpers.ManagedByValue}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			orphan, err := gc.isOrphan(ctx, obj)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !orphan {
+				continue
+			}
+			gvk, _ := apiutil.GVKForObject(obj, gc.Client.Scheme())
+			gc.Log.Info("Deleting orphan", "kind", gvk.Kind, "namespace", obj.GetNamespace(), "name", obj.GetName())
+			if err := gc.Client.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// isOrphan returns true if the source of the resource no longer exists.
+// The resources labeled as cluster aggregates, the ManifestWork, its revision history and the break-glass secret,
+// aggregate the ClusterOAuths of a cluster and so are orphans once the cluster has no ClusterOAuth,
+// the other resources are orphans once their AuthRealm or their Strategy is deleted.
+// The resources with neither label are never orphans.
+func (gc *GarbageCollector) isOrphan(ctx context.Context, obj client.Object) (bool, error) {
+	if helpers.IsClusterAggregate(obj) {
+		clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+		if err := gc.Client.List(ctx, clusterOAuths, client.InNamespace(obj.GetNamespace())); err != nil {
+			return false, err
+		}
+		return len(clusterOAuths.Items) == 0, nil
+	}
+	labels := obj.GetLabels()
+	authrealmName := labels[helpers.AuthRealmNameLabel]
+	authrealmNamespace := labels[helpers.AuthRealmNamespaceLabel]
+	if len(authrealmName) == 0 || len(authrealmNamespace) == 0 {
+		return false, nil
+	}
+	exists, err := gc.exists(ctx, &identitatemv1alpha1.AuthRealm{},
+		client.ObjectKey{Name: authrealmName, Namespace: authrealmNamespace})
+	if err != nil || !exists {
+		return !exists, err
+	}
+
+	strategyName := labels[helpers.StrategyLabel]
+	if len(strategyName) == 0 {
+		return false, nil
+	}
+	exists, err = gc.exists(ctx, &identitatemv1alpha1.Strategy{},
+		client.ObjectKey{Name: strategyName, Namespace: authrealmNamespace})
+	return !exists, err
+}
+
+func (gc *GarbageCollector) exists(ctx context.Context, obj client.Object, key client.ObjectKey) (bool, error) {
+	if err := gc.Client.Get(ctx, key, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,168 @@
+// Copyright Red Hat
+
+package garbagecollector
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+func TestCollect(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		identitatemv1alpha1.AddToScheme,
+		identitatemdexv1alpha1.AddToScheme,
+		workv1.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authrealm := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-authrealm", Namespace: "my-namespace"},
+	}
+	deletedAuthRealm := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "deleted-authrealm", Namespace: "my-namespace"},
+	}
+	strategy := &identitatemv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-strategy", Namespace: "my-namespace"},
+	}
+	clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-clusteroauth", Namespace: "cluster1"},
+	}
+	newSecret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cluster1", Labels: labels},
+		}
+	}
+	newBreakGlassSecret := func(namespace string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "idp-break-glass",
+				Namespace: namespace,
+				Labels:    helpers.ClusterAggregateLabels(),
+			},
+		}
+	}
+	newControllerRevision := func(namespace string) *appsv1.ControllerRevision {
+		return &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "idp-backplane-1234",
+				Namespace: namespace,
+				Labels:    helpers.ClusterAggregateLabels(),
+			},
+		}
+	}
+	newManifestWork := func(namespace string) *workv1.ManifestWork {
+		return &workv1.ManifestWork{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "idp-backplane",
+				Namespace: namespace,
+				Labels:    helpers.ClusterAggregateLabels(),
+			},
+		}
+	}
+	dexClient := &identitatemdexv1alpha1.DexClient{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deleted-dexclient",
+			Namespace: "deleted-authrealm",
+			Labels:    helpers.OwnerLabels(deletedAuthRealm, "", ""),
+		},
+	}
+
+	groups := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deleted-authrealm-groups",
+			Namespace: "cluster1",
+			Labels:    helpers.OwnerLabels(deletedAuthRealm, strategy.Name, "my-placement"),
+		},
+	}
+
+	objects := []client.Object{
+		authrealm,
+		strategy,
+		clusterOAuth,
+		newSecret("owned", helpers.OwnerLabels(authrealm, strategy.Name, "my-placement")),
+		newSecret("deleted-authrealm", helpers.OwnerLabels(deletedAuthRealm, strategy.Name, "my-placement")),
+		newSecret("deleted-strategy", helpers.OwnerLabels(authrealm, "deleted-strategy", "my-placement")),
+		newSecret("not-managed", helpers.AuthRealmLabels(deletedAuthRealm)),
+		newSecret("managed-by-another-tool", map[string]string{
+			helpers.ManagedByLabel:          helpers.ManagedByValue,
+			helpers.AuthRealmNameLabel:      deletedAuthRealm.Name,
+			helpers.AuthRealmNamespaceLabel: deletedAuthRealm.Namespace,
+		}),
+		newSecret("without-authrealm", map[string]string{helpers.GeneratedByLabel: helpers.ManagedByValue}),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      "idp-break-glass",
+			Namespace: "cluster3",
+			Labels:    map[string]string{helpers.ManagedByLabel: helpers.ManagedByValue},
+		}},
+		newManifestWork("cluster1"),
+		newManifestWork("cluster2"),
+		newBreakGlassSecret("cluster1"),
+		newBreakGlassSecret("cluster2"),
+		newControllerRevision("cluster1"),
+		newControllerRevision("cluster2"),
+		dexClient,
+		groups,
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	gc := &GarbageCollector{
+		Client: c,
+		Log:    logf.Log,
+	}
+	if err := gc.Collect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		obj     client.Object
+		deleted bool
+	}{
+		{name: "owned secret", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owned", Namespace: "cluster1"}}},
+		{name: "secret of a deleted authrealm", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "deleted-authrealm", Namespace: "cluster1"}}, deleted: true},
+		{name: "secret of a deleted strategy", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "deleted-strategy", Namespace: "cluster1"}}, deleted: true},
+		{name: "secret not managed", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "not-managed", Namespace: "cluster1"}}},
+		{name: "secret managed by another tool", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "managed-by-another-tool", Namespace: "cluster1"}}},
+		{name: "secret without authrealm labels", obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "without-authrealm", Namespace: "cluster1"}}},
+		{name: "manifestwork of a cluster with clusteroauths", obj: newManifestWork("cluster1")},
+		{name: "manifestwork of a cluster without clusteroauth", obj: newManifestWork("cluster2"), deleted: true},
+		{name: "break-glass secret of a cluster with clusteroauths", obj: newBreakGlassSecret("cluster1")},
+		{name: "break-glass secret of a cluster without clusteroauth", obj: newBreakGlassSecret("cluster2"), deleted: true},
+		{name: "break-glass secret not generated by the operator", obj: newBreakGlassSecret("cluster3")},
+		{name: "controllerrevision of a cluster with clusteroauths", obj: newControllerRevision("cluster1")},
+		{name: "controllerrevision of a cluster without clusteroauth", obj: newControllerRevision("cluster2"), deleted: true},
+		{name: "dexclient of a deleted authrealm", obj: &identitatemdexv1alpha1.DexClient{ObjectMeta: dexClient.ObjectMeta}, deleted: true},
+		{name: "groups of a deleted authrealm", obj: &corev1.ConfigMap{ObjectMeta: groups.ObjectMeta}, deleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Get(context.TODO(), client.ObjectKeyFromObject(tt.obj), tt.obj)
+			switch {
+			case tt.deleted && !errors.IsNotFound(err):
+				t.Errorf("expected %s to be deleted, got %v", tt.obj.GetName(), err)
+			case !tt.deleted && err != nil:
+				t.Errorf("expected %s to be kept, got %v", tt.obj.GetName(), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The field managers of the controllers, each controller owns the fields it applies
+const (
+	StrategyFieldManager          string = "idp-strategy-operator-strategy"
+	PlacementDecisionFieldManager string = "idp-strategy-operator-placementdecision"
+	ClusterOAuthFieldManager      string = "idp-strategy-operator-clusteroauth"
+)
+
+// Apply server-side applies the object with the field manager.
+// The object must have its TypeMeta set and contain only the fields owned by the field manager,
+// the fields previously applied by the field manager and missing in the object are removed.
+func Apply(ctx context.Context, c client.Client, obj client.Object, fieldManager string) error {
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	return c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// ApplyFields server-side applies the given fields of an existing object with the field manager.
+// It is used to own a few fields of an object owned by someone else, for example
+// the annotations of a ManifestWork or the spec of a Strategy.
+func ApplyFields(ctx context.Context, c client.Client,
+	gvk schema.GroupVersionKind,
+	key client.ObjectKey,
+	fields map[string]interface{},
+	fieldManager string) error {
+	u := &unstructured.Unstructured{Object: fields}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(key.Name)
+	u.SetNamespace(key.Namespace)
+	return Apply(ctx, c, u, fieldManager)
+}
@@ -11,7 +11,7 @@ import (
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 )
 
-func GetAuthrealmFromStrategy(c client.Client, strategy *identitatemv1alpha1.Strategy) (*identitatemv1alpha1.AuthRealm, error) {
+func GetAuthrealmFromStrategy(ctx context.Context, c client.Client, strategy *identitatemv1alpha1.Strategy) (*identitatemv1alpha1.AuthRealm, error) {
 	authrealm := &identitatemv1alpha1.AuthRealm{}
 	var ownerRef metav1.OwnerReference
 	//DV not needed
@@ -26,8 +26,16 @@ func GetAuthrealmFromStrategy(c client.Client, strategy *identitatemv1alpha1.Str
 			break
 		}
 	}
-	if err := c.Get(context.TODO(), client.ObjectKey{Name: ownerRef.Name, Namespace: strategy.Namespace}, authrealm); err != nil {
+	if err := c.Get(ctx, client.ObjectKey{Name: ownerRef.Name, Namespace: strategy.Namespace}, authrealm); err != nil {
 		return nil, err
 	}
 	return authrealm, nil
 }
+
+// SetDefaultStrategyType sets the type of the strategy to defaultType if the strategy doesn't set one.
+// Only the in-memory copy is defaulted, the Strategy itself is not updated.
+func SetDefaultStrategyType(strategy *identitatemv1alpha1.Strategy, defaultType identitatemv1alpha1.StrategyType) {
+	if len(strategy.Spec.Type) == 0 {
+		strategy.Spec.Type = defaultType
+	}
+}
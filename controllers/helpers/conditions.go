@@ -0,0 +1,67 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+// SetStrategyCondition sets the condition on the status of the strategy.
+// Several controllers report conditions on the same Strategy, so only the condition is merged
+// in the conditions of the strategy and patched, see PatchStrategyConditions.
+func SetStrategyCondition(ctx context.Context, c client.Client,
+	strategy *identitatemv1alpha1.Strategy,
+	condition metav1.Condition) error {
+	return PatchStrategyConditions(ctx, c, strategy, func(conditions *[]metav1.Condition) {
+		meta.SetStatusCondition(conditions, condition)
+	})
+}
+
+// RemoveStrategyCondition removes the condition of type conditionType from the status of the strategy
+func RemoveStrategyCondition(ctx context.Context, c client.Client,
+	strategy *identitatemv1alpha1.Strategy,
+	conditionType string) error {
+	return PatchStrategyConditions(ctx, c, strategy, func(conditions *[]metav1.Condition) {
+		meta.RemoveStatusCondition(conditions, conditionType)
+	})
+}
+
+// PatchStrategyConditions applies mutate to the conditions of the strategy and patches its status
+// if they changed. The conditions are a list replaced as a whole by a patch, so the patch carries
+// the resourceVersion of the strategy: if another controller changed the conditions in between
+// the strategy is read again and mutate is applied on its latest conditions,
+// instead of overwriting the conditions of the other controller.
+// The strategy is updated with the patched status.
+func PatchStrategyConditions(ctx context.Context, c client.Client,
+	strategy *identitatemv1alpha1.Strategy,
+	mutate func(conditions *[]metav1.Condition)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patched := strategy.DeepCopy()
+		mutate(&patched.Status.Conditions)
+		if equality.Semantic.DeepEqual(strategy.Status.Conditions, patched.Status.Conditions) {
+			return nil
+		}
+		err := c.Status().Patch(ctx, patched,
+			client.MergeFromWithOptions(strategy, client.MergeFromWithOptimisticLock{}))
+		switch {
+		case errors.IsConflict(err):
+			if getErr := c.Get(ctx, client.ObjectKeyFromObject(strategy), strategy); getErr != nil {
+				return getErr
+			}
+			return err
+		case err != nil:
+			return err
+		}
+		patched.DeepCopyInto(strategy)
+		return nil
+	})
+}
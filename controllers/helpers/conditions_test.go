@@ -0,0 +1,68 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+func TestSetStrategyCondition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := identitatemv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&identitatemv1alpha1.Strategy{
+		ObjectMeta: metav1.ObjectMeta{Name: "strategy", Namespace: "authrealm-ns"},
+	}).Build()
+
+	// both controllers read the strategy before any of them reports its condition
+	first := &identitatemv1alpha1.Strategy{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "strategy", Namespace: "authrealm-ns"}, first); err != nil {
+		t.Fatal(err)
+	}
+	second := first.DeepCopy()
+
+	if err := SetStrategyCondition(ctx, c, first, metav1.Condition{
+		Type: PlacementReadyCondition, Status: metav1.ConditionTrue, Reason: "Applied",
+	}); err != nil {
+		t.Fatalf("unexpected error setting the first condition: %v", err)
+	}
+	if err := SetStrategyCondition(ctx, c, second, metav1.Condition{
+		Type: DexConnectorsReadyCondition, Status: metav1.ConditionTrue, Reason: "Applied",
+	}); err != nil {
+		t.Fatalf("unexpected error setting the second condition: %v", err)
+	}
+
+	strategy := &identitatemv1alpha1.Strategy{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "strategy", Namespace: "authrealm-ns"}, strategy); err != nil {
+		t.Fatal(err)
+	}
+	for _, conditionType := range []string{PlacementReadyCondition, DexConnectorsReadyCondition} {
+		if meta.FindStatusCondition(strategy.Status.Conditions, conditionType) == nil {
+			t.Errorf("condition %s not found in %v", conditionType, strategy.Status.Conditions)
+		}
+	}
+
+	if err := RemoveStrategyCondition(ctx, c, first, PlacementReadyCondition); err != nil {
+		t.Fatalf("unexpected error removing the condition: %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "strategy", Namespace: "authrealm-ns"}, strategy); err != nil {
+		t.Fatal(err)
+	}
+	if meta.FindStatusCondition(strategy.Status.Conditions, PlacementReadyCondition) != nil {
+		t.Errorf("condition %s not removed", PlacementReadyCondition)
+	}
+	if meta.FindStatusCondition(strategy.Status.Conditions, DexConnectorsReadyCondition) == nil {
+		t.Errorf("condition %s removed with %s", DexConnectorsReadyCondition, PlacementReadyCondition)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+
+	openshiftconfigv1 "github.com/openshift/api/config/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+// HasDexConnector returns true if Dex has a connector for the type of the IdentityProvider,
+// the IdentityProviders of the other types are not served by Dex. The OpenID and LDAP IdentityProviders
+// are not served either as their issuer and host can't be configured on the connectors of the dex-operator.
+func HasDexConnector(idp *openshiftconfigv1.IdentityProvider) bool {
+	return idp.GitHub != nil || idp.GitLab != nil || idp.Google != nil
+}
+
+// GetDexNamespaceConflict returns why the AuthRealm can't use its Dex namespace, empty if it owns it.
+// The Dex namespace is named after the AuthRealm, so AuthRealms with the same name in different namespaces
+// would share it and their DexServers would serve each other's DexClients. The oldest of these AuthRealms owns
+// the Dex namespace, the connectors and DexClients of the others are not generated until it is deleted.
+func GetDexNamespaceConflict(ctx context.Context, c client.Client, authrealm *identitatemv1alpha1.AuthRealm) (string, error) {
+	authrealms := &identitatemv1alpha1.AuthRealmList{}
+	if err := c.List(ctx, authrealms); err != nil {
+		return "", err
+	}
+	owner := authrealm
+	for i := range authrealms.Items {
+		other := &authrealms.Items[i]
+		if other.Name != authrealm.Name || other.Namespace == authrealm.Namespace {
+			continue
+		}
+		if other.CreationTimestamp.Before(&owner.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&owner.CreationTimestamp) && other.Namespace < owner.Namespace) {
+			owner = other
+		}
+	}
+	if owner == authrealm {
+		return "", nil
+	}
+	return fmt.Sprintf("dex namespace %s is owned by authrealm %s/%s", DexNamespace(authrealm), owner.Namespace, owner.Name), nil
+}
@@ -0,0 +1,179 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+const (
+	// DryRunAnnotation set to "true" on a Strategy makes the controllers compute
+	// the resources without writing them, the result is rendered in the dry-run ConfigMap.
+	DryRunAnnotation string = "identityconfig.identitatem.io/dry-run"
+	// DryRunLabel is set on the dry-run ConfigMaps with the name of their Strategy
+	DryRunLabel string = "identityconfig.identitatem.io/dry-run-strategy"
+	// DryRunClusterLabel is set on the dry-run ConfigMap of a cluster with the name of the cluster
+	DryRunClusterLabel string = "identityconfig.identitatem.io/dry-run-cluster"
+	// RedactedValue replaces the sensitive values in the dry-run ConfigMap
+	RedactedValue string = "<redacted>"
+)
+
+// IsDryRun returns true if the Strategy is in dry-run mode
+func IsDryRun(strategy *identitatemv1alpha1.Strategy) bool {
+	return strategy.GetAnnotations()[DryRunAnnotation] == "true"
+}
+
+// DryRunConfigMapName returns the name of the ConfigMap holding the dry-run result of the Strategy
+func DryRunConfigMapName(strategy *identitatemv1alpha1.Strategy) string {
+	return fmt.Sprintf("%s-dry-run", strategy.Name)
+}
+
+// ClusterDryRunConfigMapName returns the name of the ConfigMap holding the dry-run result of the Strategy for a cluster
+func ClusterDryRunConfigMapName(strategy *identitatemv1alpha1.Strategy, clusterName string) string {
+	return GeneratedName(MaxNameLength, strategy.Name, "dry-run", clusterName)
+}
+
+// UpdateDryRunConfigMap adds the objects rendered in yaml to the dry-run ConfigMap of the Strategy.
+// Each controller applies its own keys with its field manager, the keys of the other controllers are kept.
+func UpdateDryRunConfigMap(ctx context.Context, c client.Client, scheme *runtime.Scheme,
+	strategy *identitatemv1alpha1.Strategy,
+	objects map[string]interface{},
+	fieldManager string) error {
+	cm, err := newDryRunConfigMap(scheme, strategy, DryRunConfigMapName(strategy), "", objects)
+	if err != nil {
+		return err
+	}
+	return Apply(ctx, c, cm, fieldManager)
+}
+
+// UpdateClusterDryRunConfigMaps renders the objects of each cluster in its own dry-run ConfigMap
+// so the size of a ConfigMap doesn't grow with the number of clusters.
+// The ConfigMaps of the clusters which are no longer previewed are deleted.
+func UpdateClusterDryRunConfigMaps(ctx context.Context, c client.Client, scheme *runtime.Scheme,
+	strategy *identitatemv1alpha1.Strategy,
+	objects map[string]map[string]interface{},
+	fieldManager string) error {
+	for clusterName, clusterObjects := range objects {
+		cm, err := newDryRunConfigMap(scheme, strategy, ClusterDryRunConfigMapName(strategy, clusterName), clusterName, clusterObjects)
+		if err != nil {
+			return err
+		}
+		if err := Apply(ctx, c, cm, fieldManager); err != nil {
+			return err
+		}
+	}
+	cms, err := listDryRunConfigMaps(ctx, c, strategy)
+	if err != nil {
+		return err
+	}
+	for i := range cms {
+		clusterName, ok := cms[i].Labels[DryRunClusterLabel]
+		if !ok {
+			continue
+		}
+		if _, ok := objects[clusterName]; ok {
+			continue
+		}
+		if err := c.Delete(ctx, &cms[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDryRunConfigMap deletes the dry-run ConfigMaps of the Strategy if any,
+// the ConfigMaps not owned by the Strategy are left untouched.
+func DeleteDryRunConfigMap(ctx context.Context, c client.Client, strategy *identitatemv1alpha1.Strategy) error {
+	cms, err := listDryRunConfigMaps(ctx, c, strategy)
+	if err != nil {
+		return err
+	}
+	//The ConfigMaps written before the label was set are only found by name
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, client.ObjectKey{Name: DryRunConfigMapName(strategy), Namespace: strategy.Namespace}, cm)
+	switch {
+	case err == nil:
+		if _, ok := cm.Labels[DryRunLabel]; !ok && isOwnedByStrategy(cm, strategy) {
+			cms = append(cms, *cm)
+		}
+	case !errors.IsNotFound(err):
+		return err
+	}
+	for i := range cms {
+		if err := c.Delete(ctx, &cms[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func newDryRunConfigMap(scheme *runtime.Scheme,
+	strategy *identitatemv1alpha1.Strategy,
+	name, clusterName string,
+	objects map[string]interface{}) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: strategy.Namespace,
+			Labels: map[string]string{
+				DryRunLabel: strategy.Name,
+			},
+		},
+		Data: make(map[string]string),
+	}
+	if len(clusterName) != 0 {
+		cm.Labels[DryRunClusterLabel] = clusterName
+	}
+	if err := controllerutil.SetOwnerReference(strategy, cm, scheme); err != nil {
+		return nil, err
+	}
+	for key, obj := range objects {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		cm.Data[key] = string(b)
+	}
+	return cm, nil
+}
+
+// listDryRunConfigMaps lists the ConfigMaps labeled for the dry-run of the Strategy and owned by it
+func listDryRunConfigMaps(ctx context.Context, c client.Client,
+	strategy *identitatemv1alpha1.Strategy) ([]corev1.ConfigMap, error) {
+	cms := &corev1.ConfigMapList{}
+	if err := c.List(ctx, cms, client.InNamespace(strategy.Namespace),
+		client.MatchingLabels{DryRunLabel: strategy.Name}); err != nil {
+		return nil, err
+	}
+	owned := make([]corev1.ConfigMap, 0, len(cms.Items))
+	for _, cm := range cms.Items {
+		if isOwnedByStrategy(&cm, strategy) {
+			owned = append(owned, cm)
+		}
+	}
+	return owned, nil
+}
+
+func isOwnedByStrategy(obj metav1.Object, strategy *identitatemv1alpha1.Strategy) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == strategy.UID {
+			return true
+		}
+	}
+	return false
+}
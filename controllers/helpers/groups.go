@@ -0,0 +1,61 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// GroupsKey is the key of the group ConfigMaps listing the groups in yaml,
+	// each group has a name and the list of its users:
+	//   - name: admins
+	//     users: [alice, bob]
+	GroupsKey string = "groups.yaml"
+)
+
+// Group is a group of users listed in a group ConfigMap
+type Group struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users,omitempty"`
+}
+
+// ParseGroups returns the groups of a group ConfigMap sorted by name with their users sorted,
+// so the groups only change with the ConfigMap. It fails if a group name is not a valid Group name
+// or if a group is listed twice.
+func ParseGroups(cm *corev1.ConfigMap) ([]Group, error) {
+	groups := []Group{}
+	if err := yaml.Unmarshal([]byte(cm.Data[GroupsKey]), &groups); err != nil {
+		return nil, fmt.Errorf("invalid %s of configmap %s/%s: %v", GroupsKey, cm.Namespace, cm.Name, err)
+	}
+	names := sets.NewString()
+	for i := range groups {
+		name := groups[i].Name
+		switch {
+		case len(name) == 0:
+			return nil, fmt.Errorf("group without name in configmap %s/%s", cm.Namespace, cm.Name)
+		case name == "." || name == ".." || strings.ContainsAny(name, "/%"):
+			return nil, fmt.Errorf("invalid group name %q in configmap %s/%s", name, cm.Namespace, cm.Name)
+		case names.Has(name):
+			return nil, fmt.Errorf("group %q listed twice in configmap %s/%s", name, cm.Namespace, cm.Name)
+		}
+		names.Insert(name)
+		users := sets.NewString()
+		for _, user := range groups[i].Users {
+			if user = strings.TrimSpace(user); len(user) != 0 {
+				users.Insert(user)
+			}
+		}
+		groups[i].Users = users.List()
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
@@ -0,0 +1,76 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   string
+		expected []Group
+		wantErr  bool
+	}{
+		{
+			name:     "empty",
+			expected: []Group{},
+		},
+		{
+			name:   "sorted",
+			groups: "- name: developers\n  users: [carol]\n- name: admins\n  users: [\" bob \", alice, bob, \"\"]\n",
+			expected: []Group{
+				{Name: "admins", Users: []string{"alice", "bob"}},
+				{Name: "developers", Users: []string{"carol"}},
+			},
+		},
+		{
+			name:   "colon and space",
+			groups: "- name: \"team a:admins\"\n",
+			expected: []Group{
+				{Name: "team a:admins", Users: []string{}},
+			},
+		},
+		{
+			name:    "not a list",
+			groups:  "admins: alice\n",
+			wantErr: true,
+		},
+		{
+			name:    "without name",
+			groups:  "- users: [alice]\n",
+			wantErr: true,
+		},
+		{
+			name:    "slash",
+			groups:  "- name: a/b\n",
+			wantErr: true,
+		},
+		{
+			name:    "dot dot",
+			groups:  "- name: ..\n",
+			wantErr: true,
+		},
+		{
+			name:    "listed twice",
+			groups:  "- name: admins\n- name: admins\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{Data: map[string]string{GroupsKey: tt.groups}}
+			groups, err := ParseGroups(cm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(groups, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, groups)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	"github.com/identitatem/idp-strategy-operator/pkg/helpers"
+)
+
+// NewAPIsChecker returns a checker failing while one of the kinds a controller needs is not served
+func NewAPIsChecker(mapper meta.RESTMapper, gvks ...schema.GroupVersionKind) healthz.Checker {
+	return func(_ *http.Request) error {
+		for _, gvk := range gvks {
+			if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				return fmt.Errorf("%s is not served: %w", gvk, err)
+			}
+		}
+		return nil
+	}
+}
+
+// NewCacheSyncChecker returns a checker failing while the informers of the cache are not synced
+func NewCacheSyncChecker(c cache.Cache, timeout time.Duration) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("the cache is not synced")
+		}
+		return nil
+	}
+}
+
+// NewHubInfoChecker returns a checker failing while the API server address of the hub can not be resolved
+func NewHubInfoChecker(c client.Client) healthz.Checker {
+	return func(req *http.Request) error {
+		if _, err := helpers.GetKubeAPIServerAddress(req.Context(), c); err != nil {
+			return fmt.Errorf("unable to resolve the hub API server address: %w", err)
+		}
+		return nil
+	}
+}
+
+// The controller-runtime metrics read by the ReconcileTracker
+const (
+	reconcileTotalMetric = "controller_runtime_reconcile_total"
+	workqueueDepthMetric = "workqueue_depth"
+)
+
+// ReconcileTracker is a liveness checker detecting a wedged controller:
+// requests are queued but no reconcile completed for the timeout.
+// A reconcile returning an error is progress as the controller is not stuck,
+// a restart wouldn't fix the error and the errors are reported by the reconcile metrics.
+// It reads the reconcile and workqueue metrics of the controllers.
+type ReconcileTracker struct {
+	// Gatherer returns the metrics, usually the controller-runtime metrics registry
+	Gatherer prometheus.Gatherer
+	// Controllers are the names of the tracked controllers
+	Controllers []string
+	// Timeout is the maximum duration without completed reconcile while requests are queued
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	progress map[string]reconcileProgress
+	now      func() time.Time
+}
+
+// reconcileProgress is the last time a controller completed a reconcile or had an empty queue
+type reconcileProgress struct {
+	completed float64
+	at        time.Time
+}
+
+// Check fails if a controller made no progress for the timeout
+func (t *ReconcileTracker) Check(_ *http.Request) error {
+	families, err := t.Gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	completed := map[string]float64{}
+	depth := map[string]float64{}
+	for _, family := range families {
+		switch family.GetName() {
+		case reconcileTotalMetric:
+			for _, m := range family.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "controller" {
+						completed[l.GetValue()] += m.GetCounter().GetValue()
+					}
+				}
+			}
+		case workqueueDepthMetric:
+			for _, m := range family.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "name" {
+						depth[l.GetValue()] = m.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.progress == nil {
+		t.progress = map[string]reconcileProgress{}
+	}
+	if t.now == nil {
+		t.now = time.Now
+	}
+	now := t.now()
+	for _, name := range t.Controllers {
+		last, ok := t.progress[name]
+		if !ok || depth[name] == 0 || completed[name] != last.completed {
+			t.progress[name] = reconcileProgress{completed: completed[name], at: now}
+			continue
+		}
+		if now.Sub(last.at) > t.Timeout {
+			return fmt.Errorf("controller %s completed no reconcile for %s with %v requests queued",
+				name, now.Sub(last.at).Round(time.Second), depth[name])
+		}
+	}
+	return nil
+}
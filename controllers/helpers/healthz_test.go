@@ -0,0 +1,89 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReconcileTracker(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	reconcileTotal := prometheus.NewCounterVec(prometheus.CounterOpts{Name: reconcileTotalMetric}, []string{"controller", "result"})
+	depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: workqueueDepthMetric}, []string{"name"})
+	registry.MustRegister(reconcileTotal, depth)
+
+	now := time.Now()
+	tracker := &ReconcileTracker{
+		Gatherer:    registry,
+		Controllers: []string{"clusteroauth"},
+		Timeout:     10 * time.Minute,
+		now:         func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name      string
+		elapsed   time.Duration
+		depth     float64
+		succeeded float64
+		failed    float64
+		wantErr   bool
+	}{
+		{
+			name: "idle",
+		},
+		{
+			name:    "idle for long",
+			elapsed: time.Hour,
+		},
+		{
+			name:    "queued",
+			elapsed: time.Minute,
+			depth:   5,
+		},
+		{
+			name:      "queued and reconciling",
+			elapsed:   8 * time.Minute,
+			depth:     5,
+			succeeded: 1,
+		},
+		{
+			name:    "queued and failing",
+			elapsed: 8 * time.Minute,
+			depth:   5,
+			failed:  10,
+		},
+		{
+			name:    "queued and failing for long",
+			elapsed: 8 * time.Minute,
+			depth:   5,
+			failed:  10,
+		},
+		{
+			name:    "queued and stuck",
+			elapsed: 8 * time.Minute,
+			depth:   5,
+		},
+		{
+			name:    "queued and stuck for long",
+			elapsed: 5 * time.Minute,
+			depth:   5,
+			wantErr: true,
+		},
+		{
+			name:    "queue drained",
+			elapsed: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.elapsed)
+		depth.WithLabelValues("clusteroauth").Set(tt.depth)
+		reconcileTotal.WithLabelValues("clusteroauth", "success").Add(tt.succeeded)
+		reconcileTotal.WithLabelValues("clusteroauth", "error").Add(tt.failed)
+		if err := tracker.Check(nil); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Check() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
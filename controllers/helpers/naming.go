@@ -0,0 +1,224 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+const (
+	// AuthRealmNameLabel is set on every resource generated for an AuthRealm
+	AuthRealmNameLabel string = "identityconfig.identitatem.io/authrealm"
+	// AuthRealmNamespaceLabel is set on every resource generated for an AuthRealm
+	AuthRealmNamespaceLabel string = "identityconfig.identitatem.io/authrealm-namespace"
+	// StrategyLabel is set on the resources generated for a Strategy with the name of the Strategy
+	StrategyLabel string = "identityconfig.identitatem.io/strategy"
+	// PlacementNameLabel is set on the resources generated for the decisions of a Placement with the name of the Placement
+	PlacementNameLabel string = "identityconfig.identitatem.io/placement"
+	// ManagedByLabel is set on every resource generated by the operator
+	ManagedByLabel string = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the value of the ManagedByLabel and of the GeneratedByLabel
+	ManagedByValue string = "idp-strategy-operator"
+	// GeneratedByLabel is set on every resource generated by the operator, the garbage collector
+	// only considers the resources with this label as the ManagedByLabel may be set by other tools
+	GeneratedByLabel string = "identityconfig.identitatem.io/generated-by"
+	// ClusterAggregateLabel is set on the resources aggregating the ClusterOAuths of all AuthRealms
+	// of a cluster, the ManifestWork, its revision history and the break-glass secret, which have no AuthRealm labels
+	ClusterAggregateLabel string = "identityconfig.identitatem.io/cluster-aggregate"
+	// AuthRealmGenerationAnnotation is set on the resources generated for an AuthRealm
+	// with the generation of the AuthRealm they were generated from
+	AuthRealmGenerationAnnotation string = "identityconfig.identitatem.io/authrealm-generation"
+	// GroupSyncLabel is set on the group ConfigMaps of the cluster namespaces,
+	// their groups are delivered to the cluster in the OAuth ManifestWork
+	GroupSyncLabel string = "identityconfig.identitatem.io/group-sync"
+
+	// PlacementReadyCondition is set on the Strategy once its Placement is generated,
+	// the message references the Placement.
+	PlacementReadyCondition string = "PlacementReady"
+	// DexConnectorsReadyCondition is set on the Strategy once the Dex connectors of its AuthRealm are configured,
+	// the message lists the IdentityProviders which can't be translated into a connector.
+	DexConnectorsReadyCondition string = "DexConnectorsReady"
+)
+
+// AuthRealmLabels returns the labels identifying the resources generated for an AuthRealm.
+// The AuthRealm name alone is not unique as two AuthRealms with the same name
+// can live in different namespaces.
+func AuthRealmLabels(authrealm *identitatemv1alpha1.AuthRealm) map[string]string {
+	return map[string]string{
+		AuthRealmNameLabel:      authrealm.Name,
+		AuthRealmNamespaceLabel: authrealm.Namespace,
+	}
+}
+
+// OwnerLabels returns the labels linking a generated resource to the AuthRealm, Strategy and Placement
+// it is generated for. Owner references can't be used as the resources live in other namespaces,
+// the garbage collector relies on these labels to delete the orphans.
+// The Strategy and Placement labels are omitted if their name is empty.
+func OwnerLabels(authrealm *identitatemv1alpha1.AuthRealm, strategyName, placementName string) map[string]string {
+	labels := AuthRealmLabels(authrealm)
+	labels[ManagedByLabel] = ManagedByValue
+	labels[GeneratedByLabel] = ManagedByValue
+	if len(strategyName) != 0 {
+		labels[StrategyLabel] = strategyName
+	}
+	if len(placementName) != 0 {
+		labels[PlacementNameLabel] = placementName
+	}
+	return labels
+}
+
+// ClusterAggregateLabels returns the labels of the resources aggregating the ClusterOAuths of a cluster
+func ClusterAggregateLabels() map[string]string {
+	return map[string]string{
+		ManagedByLabel:        ManagedByValue,
+		GeneratedByLabel:      ManagedByValue,
+		ClusterAggregateLabel: "true",
+	}
+}
+
+// IsClusterAggregate returns true if the object is a resource generated by the operator
+// which aggregates the ClusterOAuths of a cluster
+func IsClusterAggregate(obj metav1.Object) bool {
+	labels := obj.GetLabels()
+	return labels[GeneratedByLabel] == ManagedByValue && labels[ClusterAggregateLabel] == "true"
+}
+
+// OwnerAnnotations returns the annotations recording the generation of the AuthRealm a resource is generated from
+func OwnerAnnotations(authrealm *identitatemv1alpha1.AuthRealm) map[string]string {
+	return map[string]string{
+		AuthRealmGenerationAnnotation: strconv.FormatInt(authrealm.Generation, 10),
+	}
+}
+
+// IsOwnedByAuthRealm returns true if the object carries the labels of the AuthRealm
+func IsOwnedByAuthRealm(obj metav1.Object, authrealm *identitatemv1alpha1.AuthRealm) bool {
+	labels := obj.GetLabels()
+	return labels[AuthRealmNameLabel] == authrealm.Name &&
+		labels[AuthRealmNamespaceLabel] == authrealm.Namespace
+}
+
+const (
+	// MaxNameLength is the maximum length of the names of the generated resources, a DNS subdomain
+	MaxNameLength = 253
+	// MaxLabelNameLength is the maximum length of the generated names which must be a DNS label,
+	// as the names of the namespaces or of the resources whose name is used in labels
+	MaxLabelNameLength = 63
+
+	// nameHashLength is the length of the hash ending the generated names
+	nameHashLength = 8
+)
+
+// NameHash returns a short hash identifying the parts of a generated name.
+// The parts are joined with "/" which can't appear in namespaces and names, only the last part
+// may contain it, so different parts never hash the same input. The names of the generated resources
+// end with the hash of their parts, as "-" joins the readable parts but may also appear in them.
+func NameHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "/")))
+	return hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// GeneratedName returns the name of a generated resource made of the parts joined with "-" and ending with their hash.
+// The parts are lowercased and their characters which are not allowed in a DNS label are replaced with "-",
+// the readable part is truncated so the name fits in maxLength, the hash keeps the truncated names apart.
+func GeneratedName(maxLength int, parts ...string) string {
+	hash := NameHash(parts...)
+	readable := strings.Trim(sanitizeName(strings.Join(parts, "-")), "-")
+	if max := maxLength - nameHashLength - 1; len(readable) > max {
+		readable = strings.TrimRight(readable[:max], "-")
+	}
+	if len(readable) == 0 {
+		return hash
+	}
+	return readable + "-" + hash
+}
+
+// sanitizeName lowercases the name and replaces its characters not allowed in a DNS label with "-"
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, name)
+}
+
+// ClientSecretName returns the name of the secret holding the client id and secret
+// of an IdentityProvider of an AuthRealm. The secret lives in the cluster namespace.
+func ClientSecretName(authrealm *identitatemv1alpha1.AuthRealm, idpName string) string {
+	return GeneratedName(MaxNameLength, authrealm.Namespace, authrealm.Name, idpName)
+}
+
+// DexClientName returns the name of the DexClient of a cluster for an IdentityProvider of an AuthRealm.
+// The DexClient lives in the Dex namespace of the AuthRealm.
+func DexClientName(authrealm *identitatemv1alpha1.AuthRealm, clusterName, idpName string) string {
+	return GeneratedName(MaxNameLength, clusterName, authrealm.Namespace, authrealm.Name, idpName)
+}
+
+// ClusterOAuthName returns the name of the ClusterOAuth generated in the cluster namespace for an AuthRealm and a strategy type
+func ClusterOAuthName(authrealm *identitatemv1alpha1.AuthRealm, strategyType identitatemv1alpha1.StrategyType) string {
+	return GeneratedName(MaxNameLength, authrealm.Namespace, authrealm.Name, string(strategyType))
+}
+
+// IdentityProviderName returns the name in the OAuth of the managed clusters of an IdentityProvider of an AuthRealm.
+// The IdentityProviders of all AuthRealms of a cluster are consolidated in the same OAuth,
+// the prefix keeps the IdentityProviders of AuthRealms with the same IdentityProvider names apart.
+func IdentityProviderName(authrealm *identitatemv1alpha1.AuthRealm, idpName string) string {
+	return GeneratedName(MaxNameLength, authrealm.Namespace, authrealm.Name, idpName)
+}
+
+// GroupsConfigMapName returns the name of the ConfigMap holding the groups of an AuthRealm in the cluster namespace
+func GroupsConfigMapName(authrealm *identitatemv1alpha1.AuthRealm) string {
+	return GeneratedName(MaxNameLength, authrealm.Namespace, authrealm.Name, "groups")
+}
+
+// GroupName returns the name of a Group of an AuthRealm on the managed clusters,
+// "<authrealm namespace>:<authrealm name>:<group>". The prefix reserves the names of the Groups
+// of an AuthRealm as ":" can't appear in a namespace or a name, so an AuthRealm can't
+// deliver the Groups of another AuthRealm nor the Groups bound by the cluster roles of the clusters.
+// The name is not hashed so the roles can be bound to the Group.
+func GroupName(authrealm *identitatemv1alpha1.AuthRealm, group string) string {
+	return fmt.Sprintf("%s:%s:%s", authrealm.Namespace, authrealm.Name, group)
+}
+
+// DexNamespace returns the Dex namespace of an AuthRealm, it holds its DexServer and DexClients.
+// The namespace is named after the AuthRealm, only one of the AuthRealms with the same name
+// can own it, see GetDexNamespaceOwner.
+func DexNamespace(authrealm *identitatemv1alpha1.AuthRealm) string {
+	return authrealm.Name
+}
+
+// DexServerName returns the name of the DexServer of an AuthRealm in its Dex namespace
+func DexServerName(authrealm *identitatemv1alpha1.AuthRealm) string {
+	return GeneratedName(MaxLabelNameLength, authrealm.Namespace, authrealm.Name)
+}
+
+// ConnectorSecretName returns the name of the copy in the Dex namespace of the secret
+// referenced by an IdentityProvider of an AuthRealm
+func ConnectorSecretName(authrealm *identitatemv1alpha1.AuthRealm, idpName string) string {
+	return GeneratedName(MaxNameLength, authrealm.Namespace, authrealm.Name, idpName, "connector")
+}
+
+// CollisionError is returned when a resource to generate for an AuthRealm
+// already exists and belongs to something else
+type CollisionError struct {
+	Kind      string
+	Name      string
+	Namespace string
+	AuthRealm *identitatemv1alpha1.AuthRealm
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("%s %s/%s already exists and does not belong to authrealm %s/%s",
+		e.Kind, e.Namespace, e.Name, e.AuthRealm.Namespace, e.AuthRealm.Name)
+}
@@ -0,0 +1,140 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+)
+
+func TestGeneratedNames(t *testing.T) {
+	newAuthRealm := func(namespace, name string) *identitatemv1alpha1.AuthRealm {
+		return &identitatemv1alpha1.AuthRealm{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		authrealm1 *identitatemv1alpha1.AuthRealm
+		authrealm2 *identitatemv1alpha1.AuthRealm
+		cluster1   string
+		cluster2   string
+		idp1       string
+		idp2       string
+	}{
+		{
+			name:       "dash moved from the namespace to the name",
+			authrealm1: newAuthRealm("a-b", "c"),
+			authrealm2: newAuthRealm("a", "b-c"),
+			idp1:       "idp",
+			idp2:       "idp",
+		},
+		{
+			name:       "dash moved from the name to the idp",
+			authrealm1: newAuthRealm("ns", "a-b"),
+			authrealm2: newAuthRealm("ns", "a"),
+			idp1:       "c",
+			idp2:       "b-c",
+		},
+		{
+			name:       "dash moved from the cluster to the namespace",
+			authrealm1: newAuthRealm("c", "realm"),
+			authrealm2: newAuthRealm("b-c", "realm"),
+			cluster1:   "a-b",
+			cluster2:   "a",
+			idp1:       "idp",
+			idp2:       "idp",
+		},
+		{
+			name:       "same name in different namespaces",
+			authrealm1: newAuthRealm("ns1", "realm"),
+			authrealm2: newAuthRealm("ns2", "realm"),
+			idp1:       "idp",
+			idp2:       "idp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ClientSecretName(tt.authrealm1, tt.idp1) == ClientSecretName(tt.authrealm2, tt.idp2) {
+				t.Errorf("same client secret name %s", ClientSecretName(tt.authrealm1, tt.idp1))
+			}
+			if DexClientName(tt.authrealm1, tt.cluster1, tt.idp1) == DexClientName(tt.authrealm2, tt.cluster2, tt.idp2) {
+				t.Errorf("same DexClient name %s", DexClientName(tt.authrealm1, tt.cluster1, tt.idp1))
+			}
+			if IdentityProviderName(tt.authrealm1, tt.idp1) == IdentityProviderName(tt.authrealm2, tt.idp2) {
+				t.Errorf("same identity provider name %s", IdentityProviderName(tt.authrealm1, tt.idp1))
+			}
+			if ConnectorSecretName(tt.authrealm1, tt.idp1) == ConnectorSecretName(tt.authrealm2, tt.idp2) {
+				t.Errorf("same connector secret name %s", ConnectorSecretName(tt.authrealm1, tt.idp1))
+			}
+			if tt.idp1 != tt.idp2 {
+				return
+			}
+			if ClusterOAuthName(tt.authrealm1, identitatemv1alpha1.BackplaneStrategyType) ==
+				ClusterOAuthName(tt.authrealm2, identitatemv1alpha1.BackplaneStrategyType) {
+				t.Errorf("same ClusterOAuth name %s", ClusterOAuthName(tt.authrealm1, identitatemv1alpha1.BackplaneStrategyType))
+			}
+			if GroupsConfigMapName(tt.authrealm1) == GroupsConfigMapName(tt.authrealm2) {
+				t.Errorf("same groups configmap name %s", GroupsConfigMapName(tt.authrealm1))
+			}
+			if DexServerName(tt.authrealm1) == DexServerName(tt.authrealm2) {
+				t.Errorf("same DexServer name %s", DexServerName(tt.authrealm1))
+			}
+		})
+	}
+
+	authrealm := newAuthRealm("ns", "realm")
+	if ClientSecretName(authrealm, "idp") != ClientSecretName(newAuthRealm("ns", "realm"), "idp") {
+		t.Errorf("the client secret name is not stable")
+	}
+}
+
+func TestGeneratedName(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLength int
+		parts     []string
+		expected  string
+	}{
+		{
+			name:      "joined parts",
+			maxLength: MaxNameLength,
+			parts:     []string{"ns", "realm", "idp"},
+			expected:  "ns-realm-idp-" + NameHash("ns", "realm", "idp"),
+		},
+		{
+			name:      "lowercased and invalid characters replaced",
+			maxLength: MaxNameLength,
+			parts:     []string{"ns", "realm", "My IdP_1"},
+			expected:  "ns-realm-my-idp-1-" + NameHash("ns", "realm", "My IdP_1"),
+		},
+		{
+			name:      "truncated",
+			maxLength: MaxLabelNameLength,
+			parts:     []string{strings.Repeat("a", 40), strings.Repeat("b", 40)},
+			expected:  strings.Repeat("a", 40) + "-" + strings.Repeat("b", 13) + "-" + NameHash(strings.Repeat("a", 40), strings.Repeat("b", 40)),
+		},
+		{
+			name:      "no valid character",
+			maxLength: MaxNameLength,
+			parts:     []string{"@@"},
+			expected:  NameHash("@@"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := GeneratedName(tt.maxLength, tt.parts...)
+			if name != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, name)
+			}
+			if len(name) > tt.maxLength {
+				t.Errorf("name %s longer than %d", name, tt.maxLength)
+			}
+		})
+	}
+}
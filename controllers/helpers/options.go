@@ -0,0 +1,24 @@
+// Copyright Red Hat
+
+package helpers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// NewControllerOptions returns the options of a controller running maxConcurrentReconciles workers
+// and retrying the failed reconciles with an exponential backoff between baseDelay and maxDelay.
+func NewControllerOptions(maxConcurrentReconciles int, baseDelay, maxDelay time.Duration) controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+			// overall rate limit of the default controller rate limiter
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		),
+	}
+}
@@ -4,82 +4,265 @@ package placementdecision
 
 import (
 	"context"
+	"sort"
+	"time"
 
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 
-	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/clusteroauth"
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
 )
 
 const (
-	BackplaneManifestWorkName string = "idp-backplane"
+	// BackplaneManifestWorkName is the name of the ManifestWork generated by the ClusterOAuth controller
+	BackplaneManifestWorkName string = clusteroauth.BackplaneManifestWorkName
 )
 
 //DV
 //backplaneStrategy generates resources for the Backplane strategy
 func (r *PlacementDecisionReconciler) backplaneStrategy(
+	ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
 	authrealm *identitatemv1alpha1.AuthRealm,
 	placement *clusterv1alpha1.Placement,
-	placementDecision *clusterv1alpha1.PlacementDecision) error {
+	clusters []string) (reconcile.Result, error) {
+
+	groupSync, err := r.getGroupSync(ctx, strategy)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	plan, revision, err := r.rollout(ctx, strategy, authrealm, clusters, groupSync.groups)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	nextRotation, err := r.syncDexClients(ctx, strategy, authrealm, placement, clusters, plan.clusters)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.syncClusterOAuths(ctx, strategy, authrealm, placement, clusters, plan.clusters, revision); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.syncGroups(ctx, strategy, authrealm, placement, clusters, plan.clusters, groupSync); err != nil {
+		return reconcile.Result{}, err
+	}
+	requeueAfter := plan.requeueAfter
+	if nextRotation > 0 && (requeueAfter == 0 || nextRotation < requeueAfter) {
+		requeueAfter = nextRotation
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
 
-	if err := r.syncDexClients(authrealm, placementDecision); err != nil {
+//rollout computes the clusters which can receive the current revision of the authrealm and of the groups
+func (r *PlacementDecisionReconciler) rollout(
+	ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	clusters []string,
+	groups []controllershelpers.Group) (*rolloutPlan, string, error) {
+	policy, err := getRolloutPolicy(strategy)
+	if err != nil {
+		return nil, "", err
+	}
+	revision, err := getRolloutRevision(authrealm, groups)
+	if err != nil {
+		return nil, "", err
+	}
+	if policy == nil {
+		return planRollout(nil, clusters, nil, revision, time.Now()), revision, nil
+	}
+	states, err := r.getClusterRolloutStates(ctx, strategy, authrealm, clusters)
+	if err != nil {
+		return nil, "", err
+	}
+	plan := planRollout(policy, clusters, states, revision, time.Now())
+	r.Log.Info("Rollout", "strategy", strategy.Name, "revision", revision,
+		"updated", plan.updated, "total", len(clusters), "failed", plan.failed, "halted", plan.halted)
+	if err := r.setRolloutCondition(ctx, strategy, plan, len(clusters)); err != nil {
+		return nil, "", err
+	}
+	return plan, revision, nil
+}
+
+//backplaneStrategyDryRun computes the resources the Backplane strategy would generate
+//and renders them in the dry-run ConfigMap of the strategy without writing them
+func (r *PlacementDecisionReconciler) backplaneStrategyDryRun(
+	ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters []string) error {
+
+	dexClients, err := r.previewDexClients(ctx, strategy, authrealm, placement, clusters)
+	if err != nil {
 		return err
 	}
-	//Get list of managedcluster
-	mcs := &clusterv1.ManagedClusterList{}
-	if err := r.Client.List(context.TODO(), mcs); err != nil {
+
+	clusterOAuths, err := r.previewClusterOAuths(ctx, strategy, authrealm, placement, clusters, dexClients)
+	if err != nil {
 		return err
 	}
-	//Loop on all managedcluster
-	for _, mc := range mcs.Items {
-		//Check if exists
-		mw := &workv1.ManifestWork{}
-		mwExists := true
-		if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: BackplaneManifestWorkName, Namespace: mc.Name}, mw); err != nil {
-			if !errors.IsNotFound(err) {
-				return err
-			}
-			mwExists = false
-			mw = &workv1.ManifestWork{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      BackplaneManifestWorkName,
-					Namespace: mc.Name,
-				},
-			}
-		}
 
-		//If not in placementdecision then delete the manifestwork
-		if mwExists && !inPlacementDecision(mc.Name, placementDecision) {
-			if err := r.Client.Delete(context.TODO(), mw); err != nil {
-				return err
-			}
-			break
+	groups, err := r.previewGroups(ctx, strategy, authrealm, placement, clusters)
+	if err != nil {
+		return err
+	}
+
+	//The ManifestWorks read the client secrets the dry-run doesn't generate
+	dryRunClient := &dryRunClient{Client: r.Client, secrets: make(map[client.ObjectKey]*corev1.Secret)}
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	for _, dexClient := range dexClients {
+		secret := newClientSecret(authrealm, ownerLabels, dexClient.Labels["cluster"], dexClient.Labels["idp"],
+			dexClient.Spec.ClientID, controllershelpers.RedactedValue, "")
+		dryRunClient.secrets[client.ObjectKeyFromObject(secret)] = secret
+	}
+
+	//Each cluster is rendered in its own ConfigMap, a single ConfigMap would exceed
+	//the size of an object with hundreds of clusters
+	clusterObjects := make(map[string]map[string]interface{}, len(clusters))
+	for _, clusterName := range clusters {
+		clusterObjects[clusterName] = map[string]interface{}{
+			"dexclients.yaml":    make([]*identitatemdexv1alpha1.DexClient, 0),
+			"clusteroauths.yaml": make([]*identitatemv1alpha1.ClusterOAuth, 0),
+			"groups.yaml":        make([]*corev1.ConfigMap, 0),
 		}
+	}
+	for _, dexClient := range dexClients {
+		if objects, ok := clusterObjects[dexClient.Labels["cluster"]]; ok {
+			objects["dexclients.yaml"] = append(objects["dexclients.yaml"].([]*identitatemdexv1alpha1.DexClient), dexClient)
+		}
+	}
+	for _, clusterOAuth := range clusterOAuths {
+		if objects, ok := clusterObjects[clusterOAuth.Namespace]; ok {
+			objects["clusteroauths.yaml"] = append(objects["clusteroauths.yaml"].([]*identitatemv1alpha1.ClusterOAuth), clusterOAuth)
+		}
+	}
+	for _, cm := range groups {
+		if objects, ok := clusterObjects[cm.Namespace]; ok {
+			objects["groups.yaml"] = append(objects["groups.yaml"].([]*corev1.ConfigMap), cm)
+		}
+	}
+	for _, clusterName := range clusters {
+		manifestWorkClusterOAuths, err := r.previewManifestWorkClusterOAuths(ctx, clusterName, clusterOAuths)
+		if err != nil {
+			return err
+		}
+		mw, _, err := clusteroauth.BuildManifestWork(ctx, dryRunClient, clusterName, manifestWorkClusterOAuths)
+		if err != nil {
+			return err
+		}
+		if err := redactManifestWork(mw); err != nil {
+			return err
+		}
+		mw.TypeMeta = metav1.TypeMeta{
+			APIVersion: workv1.GroupVersion.String(),
+			Kind:       "ManifestWork",
+		}
+		clusterObjects[clusterName]["manifestwork.yaml"] = mw
+	}
+
+	if err := controllershelpers.UpdateClusterDryRunConfigMaps(ctx, r.Client, r.Scheme, strategy,
+		clusterObjects, controllershelpers.PlacementDecisionFieldManager); err != nil {
+		return err
+	}
+	configMaps := make(map[string]string, len(clusters))
+	for _, clusterName := range clusters {
+		configMaps[clusterName] = controllershelpers.ClusterDryRunConfigMapName(strategy, clusterName)
+	}
+	return controllershelpers.UpdateDryRunConfigMap(ctx, r.Client, r.Scheme, strategy, map[string]interface{}{
+		"decisions.yaml":  clusters,
+		"configmaps.yaml": configMaps,
+	}, controllershelpers.PlacementDecisionFieldManager)
+}
+
+//dryRunClient reads the secrets a dry-run would generate instead of the ones of the hub
+type dryRunClient struct {
+	client.Client
+	secrets map[client.ObjectKey]*corev1.Secret
+}
 
-		//Create manifestwork
-		mw.Spec.Workload.Manifests = make([]workv1.Manifest, 0)
+func (c *dryRunClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if secret, ok := obj.(*corev1.Secret); ok {
+		if s, ok := c.secrets[key]; ok {
+			s.DeepCopyInto(secret)
+			return nil
+		}
+	}
+	return c.Client.Get(ctx, key, obj)
+}
 
-		// clientSecret, err := r.addClientSecret(mc.Name, mw)
-		// if err != nil {
-		// 	return err
-		// }
+//previewManifestWorkClusterOAuths returns the ClusterOAuths of the cluster once the previewed ClusterOAuths are applied,
+//the ClusterOAuths of the other AuthRealms are kept as they share the ManifestWork of the cluster
+func (r *PlacementDecisionReconciler) previewManifestWorkClusterOAuths(ctx context.Context,
+	clusterName string,
+	previews []*identitatemv1alpha1.ClusterOAuth) ([]identitatemv1alpha1.ClusterOAuth, error) {
+	clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+	if err := r.Client.List(ctx, clusterOAuths, client.InNamespace(clusterName)); err != nil {
+		return nil, err
+	}
+	items := make([]identitatemv1alpha1.ClusterOAuth, 0, len(clusterOAuths.Items))
+	previewed := make(map[string]*identitatemv1alpha1.ClusterOAuth)
+	for _, preview := range previews {
+		if preview.Namespace == clusterName {
+			previewed[preview.Name] = preview
+		}
+	}
+	for _, clusterOAuth := range clusterOAuths.Items {
+		if _, ok := previewed[clusterOAuth.Name]; !ok {
+			items = append(items, clusterOAuth)
+		}
+	}
+	for _, preview := range previewed {
+		items = append(items, *preview)
+	}
+	//The ClusterOAuths are listed by name, the first ones keep the IdentityProvider names they share
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	return items, nil
+}
 
-		switch mwExists {
-		case true:
-			if err := r.Client.Update(context.TODO(), mw); err != nil {
+//redactManifestWork replaces the values of the secrets of the manifestwork
+func redactManifestWork(mw *workv1.ManifestWork) error {
+	for i, manifest := range mw.Spec.Workload.Manifests {
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(manifest.Raw); err != nil {
+			return err
+		}
+		if u.GetKind() != "Secret" {
+			continue
+		}
+		for _, field := range []string{"data", "stringData"} {
+			values, found, err := unstructured.NestedMap(u.Object, field)
+			if err != nil {
 				return err
 			}
-		case false:
-			if err := r.Client.Create(context.Background(), mw); err != nil {
+			if !found {
+				continue
+			}
+			for k := range values {
+				values[k] = controllershelpers.RedactedValue
+			}
+			if err := unstructured.SetNestedMap(u.Object, values, field); err != nil {
 				return err
 			}
 		}
-
+		data, err := u.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		mw.Spec.Workload.Manifests[i].Raw = data
 	}
 	return nil
 }
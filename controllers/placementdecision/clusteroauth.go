@@ -0,0 +1,265 @@
+// Copyright Red Hat
+
+package placementdecision
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	openshiftconfigv1 "github.com/openshift/api/config/v1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// The claims annotations of an AuthRealm override the claims mapping of the OpenID IdentityProviders
+	// generated for its clusters, the value is a comma separated list of claims ("preferred_username,email").
+
+	// PreferredUsernameClaimsAnnotation sets the claims used as preferred user name
+	PreferredUsernameClaimsAnnotation string = "identityconfig.identitatem.io/preferred-username-claims"
+	// NameClaimsAnnotation sets the claims used as display name
+	NameClaimsAnnotation string = "identityconfig.identitatem.io/name-claims"
+	// EmailClaimsAnnotation sets the claims used as email address
+	EmailClaimsAnnotation string = "identityconfig.identitatem.io/email-claims"
+
+	// dexConnectorParameter selects the Dex connector of the IdentityProvider in the authorize requests
+	dexConnectorParameter string = "connector_id"
+)
+
+// openIDScopes are requested to Dex in addition to openid, the groups are used by the group synchronization
+var openIDScopes = []string{"email", "profile", "groups"}
+
+// getOpenIDClaims returns the claims mapping of the AuthRealm, the defaults are the standard claims
+func getOpenIDClaims(authrealm *identitatemv1alpha1.AuthRealm) openshiftconfigv1.OpenIDClaims {
+	claims := openshiftconfigv1.OpenIDClaims{
+		PreferredUsername: []string{"preferred_username"},
+		Name:              []string{"name"},
+		Email:             []string{"email"},
+	}
+	for annotation, claim := range map[string]*[]string{
+		PreferredUsernameClaimsAnnotation: &claims.PreferredUsername,
+		NameClaimsAnnotation:              &claims.Name,
+		EmailClaimsAnnotation:             &claims.Email,
+	} {
+		value, ok := authrealm.GetAnnotations()[annotation]
+		if !ok {
+			continue
+		}
+		*claim = make([]string, 0)
+		for _, c := range strings.Split(value, ",") {
+			if c = strings.TrimSpace(c); len(c) != 0 {
+				*claim = append(*claim, c)
+			}
+		}
+	}
+	return claims
+}
+
+// getClaimsAnnotations returns the claims annotations set on the AuthRealm
+func getClaimsAnnotations(authrealm *identitatemv1alpha1.AuthRealm) map[string]string {
+	annotations := make(map[string]string)
+	for _, annotation := range []string{PreferredUsernameClaimsAnnotation, NameClaimsAnnotation, EmailClaimsAnnotation} {
+		if value, ok := authrealm.GetAnnotations()[annotation]; ok {
+			annotations[annotation] = value
+		}
+	}
+	return annotations
+}
+
+// newClusterOAuth returns the ClusterOAuth of a cluster with an OpenID IdentityProvider per IdentityProvider
+// of the authrealm served by Dex. The IdentityProviders authenticate against the Dex issuer with the client
+// of the DexClient of the cluster, clientIDs are the client ids by IdentityProvider name.
+// The client secret references the client secret of the cluster namespace which is delivered to the cluster
+// by the ClusterOAuth controller.
+func newClusterOAuth(strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	ownerLabels map[string]string,
+	clusterName, issuer string,
+	clientIDs map[string]string) *identitatemv1alpha1.ClusterOAuth {
+	clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: identitatemv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "ClusterOAuth",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        controllershelpers.ClusterOAuthName(authrealm, strategy.Spec.Type),
+			Namespace:   clusterName,
+			Labels:      map[string]string{},
+			Annotations: controllershelpers.OwnerAnnotations(authrealm),
+		},
+		Spec: identitatemv1alpha1.ClusterOAuthSpec{
+			OAuth: &openshiftconfigv1.OAuth{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: openshiftconfigv1.SchemeGroupVersion.String(),
+					Kind:       "OAuth",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+			},
+		},
+	}
+	for k, v := range ownerLabels {
+		clusterOAuth.Labels[k] = v
+	}
+	claims := getOpenIDClaims(authrealm)
+	for i := range authrealm.Spec.IdentityProviders {
+		idp := &authrealm.Spec.IdentityProviders[i]
+		if !controllershelpers.HasDexConnector(idp) {
+			continue
+		}
+		clusterOAuth.Spec.OAuth.Spec.IdentityProviders = append(clusterOAuth.Spec.OAuth.Spec.IdentityProviders,
+			openshiftconfigv1.IdentityProvider{
+				Name:          controllershelpers.IdentityProviderName(authrealm, idp.Name),
+				MappingMethod: idp.MappingMethod,
+				IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+					Type: openshiftconfigv1.IdentityProviderTypeOpenID,
+					OpenID: &openshiftconfigv1.OpenIDIdentityProvider{
+						ClientID: clientIDs[idp.Name],
+						ClientSecret: openshiftconfigv1.SecretNameReference{
+							Name: controllershelpers.ClientSecretName(authrealm, idp.Name),
+						},
+						ExtraScopes: append([]string{}, openIDScopes...),
+						ExtraAuthorizeParameters: map[string]string{
+							dexConnectorParameter: idp.Name,
+						},
+						Issuer: issuer,
+						Claims: claims,
+					},
+				},
+			})
+	}
+	return clusterOAuth
+}
+
+// getDexIssuer returns the issuer URL of the DexServer of the authrealm, empty if the DexServer is not found
+func (r *PlacementDecisionReconciler) getDexIssuer(ctx context.Context, authrealm *identitatemv1alpha1.AuthRealm) (string, error) {
+	dexServer := &identitatemdexv1alpha1.DexServer{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: controllershelpers.DexServerName(authrealm), Namespace: controllershelpers.DexNamespace(authrealm)}, dexServer)
+	switch {
+	case errors.IsNotFound(err):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	return dexServer.Spec.Issuer, nil
+}
+
+// syncClusterOAuths generates the ClusterOAuths of the rolloutClusters at the rollout revision and deletes
+// the ClusterOAuths of the clusters which are no longer decided.
+// The rollout timestamp of a ClusterOAuth is kept while its revision doesn't change.
+// It must run after syncDexClients which generates the client secrets of the rolloutClusters.
+func (r *PlacementDecisionReconciler) syncClusterOAuths(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters, rolloutClusters []string,
+	revision string) error {
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	decidedClusters := sets.NewString(clusters...)
+	updatedClusters := sets.NewString(rolloutClusters...)
+
+	clusterOAuths := &identitatemv1alpha1.ClusterOAuthList{}
+	if err := r.Client.List(ctx, clusterOAuths, client.MatchingLabels(ownerLabels)); err != nil {
+		return err
+	}
+	for i := range clusterOAuths.Items {
+		//The ClusterOAuths named before the current naming are replaced once the cluster is updated
+		if decidedClusters.Has(clusterOAuths.Items[i].Namespace) &&
+			(clusterOAuths.Items[i].Name == controllershelpers.ClusterOAuthName(authrealm, strategy.Spec.Type) ||
+				!updatedClusters.Has(clusterOAuths.Items[i].Namespace)) {
+			continue
+		}
+		if err := r.Client.Delete(ctx, &clusterOAuths.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	issuer, err := r.getDexIssuer(ctx, authrealm)
+	if err != nil {
+		return err
+	}
+
+	for _, clusterName := range rolloutClusters {
+		clientIDs := make(map[string]string)
+		for _, idp := range authrealm.Spec.IdentityProviders {
+			clientSecret := &corev1.Secret{}
+			if err := r.Get(ctx,
+				client.ObjectKey{Name: controllershelpers.ClientSecretName(authrealm, idp.Name), Namespace: clusterName},
+				clientSecret); err != nil {
+				return err
+			}
+			clientIDs[idp.Name] = string(clientSecret.Data["client-id"])
+		}
+
+		rolloutTimestamp := time.Now().UTC().Format(time.RFC3339)
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+		err := r.Client.Get(ctx,
+			client.ObjectKey{Name: controllershelpers.ClusterOAuthName(authrealm, strategy.Spec.Type), Namespace: clusterName},
+			clusterOAuth)
+		switch {
+		case err == nil:
+			if !controllershelpers.IsOwnedByAuthRealm(clusterOAuth, authrealm) {
+				return &controllershelpers.CollisionError{
+					Kind:      "ClusterOAuth",
+					Name:      clusterOAuth.Name,
+					Namespace: clusterOAuth.Namespace,
+					AuthRealm: authrealm,
+				}
+			}
+			if clusterOAuth.GetAnnotations()[RolloutRevisionAnnotation] == revision {
+				rolloutTimestamp = clusterOAuth.GetAnnotations()[RolloutTimestampAnnotation]
+			}
+		case !errors.IsNotFound(err):
+			return err
+		}
+
+		clusterOAuth = newClusterOAuth(strategy, authrealm, ownerLabels, clusterName, issuer, clientIDs)
+		clusterOAuth.Annotations[RolloutRevisionAnnotation] = revision
+		clusterOAuth.Annotations[RolloutTimestampAnnotation] = rolloutTimestamp
+		if err := controllershelpers.Apply(ctx, r.Client, clusterOAuth, controllershelpers.PlacementDecisionFieldManager); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previewClusterOAuths returns the ClusterOAuths syncClusterOAuths would generate for the clusters
+// with the client ids of the dexClients returned by previewDexClients
+func (r *PlacementDecisionReconciler) previewClusterOAuths(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters []string,
+	dexClients []*identitatemdexv1alpha1.DexClient) ([]*identitatemv1alpha1.ClusterOAuth, error) {
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	issuer, err := r.getDexIssuer(ctx, authrealm)
+	if err != nil {
+		return nil, err
+	}
+	clientIDs := make(map[string]map[string]string)
+	for _, clusterName := range clusters {
+		clientIDs[clusterName] = make(map[string]string)
+	}
+	for _, dexClient := range dexClients {
+		if ids, ok := clientIDs[dexClient.GetLabels()["cluster"]]; ok {
+			ids[dexClient.GetLabels()["idp"]] = dexClient.Spec.ClientID
+		}
+	}
+	clusterOAuths := make([]*identitatemv1alpha1.ClusterOAuth, 0)
+	for _, clusterName := range clusters {
+		clusterOAuths = append(clusterOAuths,
+			newClusterOAuth(strategy, authrealm, ownerLabels, clusterName, issuer, clientIDs[clusterName]))
+	}
+	return clusterOAuths, nil
+}
@@ -5,13 +5,20 @@ package placementdecision
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
@@ -21,6 +28,7 @@ import (
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	workv1 "open-cluster-management.io/api/work/v1"
 
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
 	"github.com/identitatem/idp-strategy-operator/pkg/helpers"
 )
 
@@ -60,137 +68,562 @@ func (r *PlacementDecisionReconciler) addOAuth(dclusterName string, mw *workv1.M
 	return nil
 }
 
-func (r *PlacementDecisionReconciler) syncDexClients(authrealm *identitatemv1alpha1.AuthRealm, placementDecision *clusterv1alpha1.PlacementDecision) error {
+// syncDexClients generates the DexClients of the rolloutClusters and deletes
+// the DexClients of the clusters which are no longer decided.
+// The rolloutClusters are the decided clusters which can receive the current authrealm configuration.
+// It returns the duration before the next rotation of a client secret, zero if none is planned.
+func (r *PlacementDecisionReconciler) syncDexClients(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters, rolloutClusters []string) (time.Duration, error) {
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	decidedClusters := sets.NewString(clusters...)
+	updatedClusters := sets.NewString(rolloutClusters...)
+	idpNames := sets.NewString()
+	for _, idp := range authrealm.Spec.IdentityProviders {
+		idpNames.Insert(idp.Name)
+	}
 
+	//Only the DexClients of this authrealm are listed, the DexClients of the authrealms with the same name
+	//are deleted by their own reconcile as the Dex namespace is owned by a single authrealm
 	dexClients := &identitatemdexv1alpha1.DexClientList{}
-	if err := r.Client.List(context.TODO(), dexClients, &client.ListOptions{Namespace: authrealm.Name}); err != nil {
-		return err
+	if err := r.Client.List(ctx, dexClients,
+		client.InNamespace(controllershelpers.DexNamespace(authrealm)),
+		client.MatchingLabels(controllershelpers.AuthRealmLabels(authrealm))); err != nil {
+		return 0, err
 	}
 	for i, dexClient := range dexClients.Items {
-		for _, idp := range authrealm.Spec.IdentityProviders {
-			if !inPlacementDecision(dexClient.GetLabels()["cluster"], placementDecision) &&
-				dexClient.GetLabels()["idp"] == idp.Name {
-				if err := r.Client.Delete(context.TODO(), &dexClients.Items[i]); err != nil {
-					return err
-				}
+		clusterName := dexClient.GetLabels()["cluster"]
+		idpName := dexClient.GetLabels()["idp"]
+		//The DexClients of a removed idp are kept until the cluster receives the new configuration
+		if decidedClusters.Has(clusterName) &&
+			(idpNames.Has(idpName) || !updatedClusters.Has(clusterName)) {
+			//The DexClients named before the names ended with a hash are replaced
+			if dexClient.Name == controllershelpers.DexClientName(authrealm, clusterName, idpName) ||
+				!updatedClusters.Has(clusterName) {
+				continue
 			}
+		} else if err := r.deleteClientSecret(ctx, authrealm, clusterName, idpName); err != nil {
+			return 0, err
+		}
+		if err := r.Client.Delete(ctx, &dexClients.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return 0, err
 		}
 	}
-	for _, decision := range placementDecision.Status.Decisions {
+
+	if err := r.deleteLegacyDexClients(ctx, authrealm, decidedClusters, updatedClusters); err != nil {
+		return 0, err
+	}
+
+	redirectTemplateData, err := r.getRedirectTemplateData(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var nextRotation time.Duration
+
+	for _, clusterName := range rolloutClusters {
 		for _, idp := range authrealm.Spec.IdentityProviders {
-			clusterName := decision.ClusterName
-			clientSecret := &corev1.Secret{}
-			if err := r.Get(context.TODO(), client.ObjectKey{Name: idp.Name, Namespace: clusterName}, clientSecret); err != nil {
-				if !errors.IsNotFound(err) {
-					return err
-				}
-				clientSecret = &corev1.Secret{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      idp.Name,
-						Namespace: clusterName,
-					},
-					Data: map[string][]byte{
-						"client-id":     []byte(clusterName),
-						"client-secret": []byte(helpers.RandStringRunes(32)),
-					},
-				}
-				if err := r.Create(context.TODO(), clientSecret); err != nil {
-					return err
-				}
+			clientSecret, err := r.getOrCreateClientSecret(ctx, authrealm, ownerLabels, clusterName, idp.Name)
+			if err != nil {
+				return 0, err
+			}
+			if d := r.clientSecretRotation(clientSecret, time.Now()); d > 0 && (nextRotation == 0 || d < nextRotation) {
+				nextRotation = d
+			}
+			redirectURI, err := r.getRedirectURI(redirectTemplateData, clusterName,
+				controllershelpers.IdentityProviderName(authrealm, idp.Name))
+			if err != nil {
+				return 0, err
 			}
-			dexClientExists := true
 			dexClient := &identitatemdexv1alpha1.DexClient{}
-			if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: clusterName, Namespace: authrealm.Name}, dexClient); err != nil {
-				if !errors.IsNotFound(err) {
-					return err
-				}
-				dexClientExists = false
-				dexClient = &identitatemdexv1alpha1.DexClient{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      fmt.Sprintf("%s-%s", clusterName, idp.Name),
-						Namespace: authrealm.Name,
-						Labels: map[string]string{
-							"cluster": clusterName,
-							"idp":     idp.Name,
-						},
-					},
+			dexClientName := controllershelpers.DexClientName(authrealm, clusterName, idp.Name)
+			err = r.Client.Get(ctx, client.ObjectKey{Name: dexClientName, Namespace: controllershelpers.DexNamespace(authrealm)}, dexClient)
+			switch {
+			case err == nil:
+				if !controllershelpers.IsOwnedByAuthRealm(dexClient, authrealm) {
+					return 0, &controllershelpers.CollisionError{
+						Kind:      "DexClient",
+						Name:      dexClient.Name,
+						Namespace: dexClient.Namespace,
+						AuthRealm: authrealm,
+					}
 				}
+			case !errors.IsNotFound(err):
+				return 0, err
 			}
 
+			dexClient = newDexClient(authrealm, ownerLabels, clusterName, idp.Name)
 			dexClient.Spec.ClientID = string(clientSecret.Data["client-id"])
 			dexClient.Spec.ClientSecret = string(clientSecret.Data["client-secret"])
+			dexClient.Spec.RedirectURIs = []string{redirectURI}
 
-			apiServerURL, err := helpers.GetKubeAPIServerAddress(r.Client)
-			if err != nil {
-				return err
-			}
-			u, err := url.Parse(apiServerURL)
-			if err != nil {
-				return err
+			if err := controllershelpers.Apply(ctx, r.Client, dexClient, controllershelpers.PlacementDecisionFieldManager); err != nil {
+				return 0, err
 			}
+		}
+	}
+	return nextRotation, nil
+}
+
+// deleteLegacyDexClients deletes the DexClients generated before they had the owner labels, named <cluster>-<idp>
+// with only the cluster and idp labels. They are kept for the decided clusters which don't have the current
+// configuration yet. The Dex namespace is owned by the authrealm so these DexClients belong to it.
+func (r *PlacementDecisionReconciler) deleteLegacyDexClients(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	decidedClusters, updatedClusters sets.String) error {
+	dexClients := &identitatemdexv1alpha1.DexClientList{}
+	if err := r.Client.List(ctx, dexClients,
+		client.InNamespace(controllershelpers.DexNamespace(authrealm)),
+		client.HasLabels{"cluster", "idp"}); err != nil {
+		return err
+	}
+	for i, dexClient := range dexClients.Items {
+		labels := dexClient.GetLabels()
+		if _, ok := labels[controllershelpers.ManagedByLabel]; ok {
+			continue
+		}
+		if _, ok := labels[controllershelpers.AuthRealmNameLabel]; ok {
+			continue
+		}
+		if dexClient.Name != fmt.Sprintf("%s-%s", labels["cluster"], labels["idp"]) {
+			continue
+		}
+		if decidedClusters.Has(labels["cluster"]) && !updatedClusters.Has(labels["cluster"]) {
+			continue
+		}
+		if err := r.Client.Delete(ctx, &dexClients.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
 
-			host, _, err := net.SplitHostPort(u.Host)
+// deleteDexClients deletes the DexClients of the authrealm, they are deleted when the Dex namespace
+// is owned by another authrealm so its DexServer doesn't serve them
+func (r *PlacementDecisionReconciler) deleteDexClients(ctx context.Context, authrealm *identitatemv1alpha1.AuthRealm) error {
+	dexClients := &identitatemdexv1alpha1.DexClientList{}
+	if err := r.Client.List(ctx, dexClients,
+		client.InNamespace(controllershelpers.DexNamespace(authrealm)),
+		client.MatchingLabels(controllershelpers.AuthRealmLabels(authrealm))); err != nil {
+		return err
+	}
+	for i := range dexClients.Items {
+		if err := r.Client.Delete(ctx, &dexClients.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// previewDexClients returns the DexClients syncDexClients would generate for the clusters.
+// The client secrets are neither generated nor returned.
+func (r *PlacementDecisionReconciler) previewDexClients(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters []string) ([]*identitatemdexv1alpha1.DexClient, error) {
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	redirectTemplateData, err := r.getRedirectTemplateData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dexClients := make([]*identitatemdexv1alpha1.DexClient, 0)
+	for _, clusterName := range clusters {
+		for _, idp := range authrealm.Spec.IdentityProviders {
+			dexClient := newDexClient(authrealm, ownerLabels, clusterName, idp.Name)
+			dexClient.Spec.ClientID = controllershelpers.DexClientName(authrealm, clusterName, idp.Name)
+			clientSecret := &corev1.Secret{}
+			if err := r.Get(ctx,
+				client.ObjectKey{Name: controllershelpers.ClientSecretName(authrealm, idp.Name), Namespace: clusterName},
+				clientSecret); err != nil {
+				if !errors.IsNotFound(err) {
+					return nil, err
+				}
+			} else if controllershelpers.IsOwnedByAuthRealm(clientSecret, authrealm) {
+				dexClient.Spec.ClientID = string(clientSecret.Data["client-id"])
+			}
+			dexClient.Spec.ClientSecret = controllershelpers.RedactedValue
+			redirectURI, err := r.getRedirectURI(redirectTemplateData, clusterName,
+				controllershelpers.IdentityProviderName(authrealm, idp.Name))
 			if err != nil {
-				return err
+				return nil, err
 			}
+			dexClient.Spec.RedirectURIs = []string{redirectURI}
+			dexClients = append(dexClients, dexClient)
+		}
+	}
+	return dexClients, nil
+}
+
+// newDexClient returns an empty DexClient of a cluster for an IdentityProvider of the authrealm
+// with the owner labels
+func newDexClient(authrealm *identitatemv1alpha1.AuthRealm, ownerLabels map[string]string, clusterName, idpName string) *identitatemdexv1alpha1.DexClient {
+	dexClient := &identitatemdexv1alpha1.DexClient{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: identitatemdexv1alpha1.GroupVersion.String(),
+			Kind:       "DexClient",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        controllershelpers.DexClientName(authrealm, clusterName, idpName),
+			Namespace:   controllershelpers.DexNamespace(authrealm),
+			Labels:      map[string]string{},
+			Annotations: controllershelpers.OwnerAnnotations(authrealm),
+		},
+	}
+	for k, v := range ownerLabels {
+		dexClient.Labels[k] = v
+	}
+	dexClient.Labels["cluster"] = clusterName
+	dexClient.Labels["idp"] = idpName
+	return dexClient
+}
 
-			host = strings.Replace(host, "api", "apps", 1)
+const (
+	// DefaultRedirectTemplate renders the redirect URI of the DexClients if no template is configured
+	DefaultRedirectTemplate string = "{{.Scheme}}://{{.AppsHost}}/oauth2callback/idpserver"
 
-			redirectURI := fmt.Sprintf("%s://%s/oauth2callback/idpserver", u.Scheme, host)
-			dexClient.Spec.RedirectURIs = []string{redirectURI}
-			switch dexClientExists {
-			case true:
-				return r.Client.Update(context.TODO(), dexClient)
-			case false:
-				return r.Client.Create(context.Background(), dexClient)
-			}
+	// ClientSecretRotatedAtAnnotation is set on the client secrets when their secret is rotated
+	ClientSecretRotatedAtAnnotation string = "identityconfig.identitatem.io/client-secret-rotated-at"
+
+	// openIDClientSecretKey is the key of the client secret read by the OpenShift OpenID IdentityProvider
+	openIDClientSecretKey string = "clientSecret"
+)
+
+// RedirectTemplateData are the values available in the redirect template
+type RedirectTemplateData struct {
+	// Scheme is the scheme of the hub API server
+	Scheme string
+	// AppsHost is the host of the hub applications
+	AppsHost string
+	// ClusterName is the name of the managed cluster of the DexClient
+	ClusterName string
+	// IdentityProvider is the name in the OAuth of the cluster of the IdentityProvider of the DexClient
+	IdentityProvider string
+}
+
+// ParseRedirectTemplate parses a redirect template and checks it renders with all values set
+func ParseRedirectTemplate(text string) (*template.Template, error) {
+	t, err := template.New("redirect").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, RedirectTemplateData{
+		Scheme:           "https",
+		AppsHost:         "apps.example.com",
+		ClusterName:      "cluster",
+		IdentityProvider: "idp",
+	}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// getRedirectTemplateData returns the values of the hub in the redirect template
+func (r *PlacementDecisionReconciler) getRedirectTemplateData(ctx context.Context) (*RedirectTemplateData, error) {
+	apiServerURL, err := helpers.GetKubeAPIServerAddress(ctx, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(apiServerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedirectTemplateData{
+		Scheme:   u.Scheme,
+		AppsHost: strings.Replace(host, "api", "apps", 1),
+	}, nil
+}
+
+// getRedirectURI renders the redirect URI of the DexClient of the cluster for the IdentityProvider
+func (r *PlacementDecisionReconciler) getRedirectURI(data *RedirectTemplateData, clusterName, idpName string) (string, error) {
+	t := r.RedirectTemplate
+	if t == nil {
+		var err error
+		if t, err = ParseRedirectTemplate(DefaultRedirectTemplate); err != nil {
+			return "", err
+		}
+	}
+	values := *data
+	values.ClusterName = clusterName
+	values.IdentityProvider = idpName
+	var b strings.Builder
+	if err := t.Execute(&b, values); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// clientSecretRotation returns the duration before the rotation of the client secret,
+// zero or less if the secret must be rotated. It returns zero if the rotation is disabled.
+func (r *PlacementDecisionReconciler) clientSecretRotation(clientSecret *corev1.Secret, now time.Time) time.Duration {
+	if r.RotationPeriod <= 0 {
+		return 0
+	}
+	rotatedAt := clientSecret.CreationTimestamp.Time
+	if t, err := time.Parse(time.RFC3339, clientSecret.GetAnnotations()[ClientSecretRotatedAtAnnotation]); err == nil {
+		rotatedAt = t
+	}
+	if d := rotatedAt.Add(r.RotationPeriod).Sub(now); d > 0 {
+		return d
+	}
+	return -1
+}
+
+// getOrCreateClientSecret returns the secret holding the client id and secret of the cluster
+// for the IdentityProvider, the secret is generated if it doesn't exist yet.
+// The owner labels are added to the secrets generated before they were introduced.
+// An error is returned if the secret exists but belongs to another authrealm.
+func (r *PlacementDecisionReconciler) getOrCreateClientSecret(ctx context.Context, authrealm *identitatemv1alpha1.AuthRealm,
+	ownerLabels map[string]string,
+	clusterName, idpName string) (*corev1.Secret, error) {
+	clientSecret := &corev1.Secret{}
+	clientSecretName := controllershelpers.ClientSecretName(authrealm, idpName)
+	if err := r.Get(ctx, client.ObjectKey{Name: clientSecretName, Namespace: clusterName}, clientSecret); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		clientSecret = newClientSecret(authrealm, ownerLabels, clusterName, idpName,
+			//The client-id must be unique in the Dex server
+			controllershelpers.DexClientName(authrealm, clusterName, idpName),
+			helpers.RandStringRunes(32), "")
+		if err := controllershelpers.Apply(ctx, r.Client, clientSecret, controllershelpers.PlacementDecisionFieldManager); err != nil {
+			return nil, err
+		}
+		//The secret named before the names ended with a hash is replaced by the new one
+		if err := r.deleteOwnedSecret(ctx, authrealm, legacyClientSecretName(authrealm, idpName), clusterName); err != nil {
+			return nil, err
+		}
+		return clientSecret, nil
+	}
+	if !controllershelpers.IsOwnedByAuthRealm(clientSecret, authrealm) {
+		return nil, &controllershelpers.CollisionError{
+			Kind:      "Secret",
+			Name:      clientSecret.Name,
+			Namespace: clientSecret.Namespace,
+			AuthRealm: authrealm,
+		}
+	}
+	update := false
+	for k, v := range ownerLabels {
+		if clientSecret.Labels[k] != v {
+			update = true
+		}
+	}
+	secret := string(clientSecret.Data["client-secret"])
+	rotatedAt := clientSecret.GetAnnotations()[ClientSecretRotatedAtAnnotation]
+	now := time.Now()
+	if r.clientSecretRotation(clientSecret, now) < 0 {
+		r.Log.Info("Rotating client secret", "namespace", clientSecret.Namespace, "name", clientSecret.Name)
+		secret = helpers.RandStringRunes(32)
+		rotatedAt = now.UTC().Format(time.RFC3339)
+		update = true
+	}
+	//The clientSecret key is added to the secrets generated before the OpenID IdentityProviders
+	if string(clientSecret.Data[openIDClientSecretKey]) != secret {
+		update = true
+	}
+	if !update {
+		return clientSecret, nil
+	}
+	clientSecret = newClientSecret(authrealm, ownerLabels, clusterName, idpName,
+		string(clientSecret.Data["client-id"]), secret, rotatedAt)
+	if err := controllershelpers.Apply(ctx, r.Client, clientSecret, controllershelpers.PlacementDecisionFieldManager); err != nil {
+		return nil, err
+	}
+	return clientSecret, nil
+}
+
+// newClientSecret returns the secret holding the client id and secret of the cluster for the IdentityProvider,
+// rotatedAt is the time of the last rotation of the secret, empty if never rotated.
+func newClientSecret(authrealm *identitatemv1alpha1.AuthRealm,
+	ownerLabels map[string]string,
+	clusterName, idpName, clientID, secret, rotatedAt string) *corev1.Secret {
+	clientSecret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        controllershelpers.ClientSecretName(authrealm, idpName),
+			Namespace:   clusterName,
+			Labels:      map[string]string{},
+			Annotations: controllershelpers.OwnerAnnotations(authrealm),
+		},
+		Data: map[string][]byte{
+			"client-id":     []byte(clientID),
+			"client-secret": []byte(secret),
+			//The OpenID IdentityProvider of the cluster reads the secret from the clientSecret key
+			openIDClientSecretKey: []byte(secret),
+		},
+	}
+	for k, v := range ownerLabels {
+		clientSecret.Labels[k] = v
+	}
+	if len(rotatedAt) != 0 {
+		clientSecret.Annotations[ClientSecretRotatedAtAnnotation] = rotatedAt
+	}
+	return clientSecret
+}
+
+// deleteClientSecret deletes the client secret of the cluster for the IdentityProvider
+// if it belongs to the authrealm
+func (r *PlacementDecisionReconciler) deleteClientSecret(ctx context.Context, authrealm *identitatemv1alpha1.AuthRealm,
+	clusterName, idpName string) error {
+	for _, name := range []string{
+		controllershelpers.ClientSecretName(authrealm, idpName),
+		legacyClientSecretName(authrealm, idpName),
+	} {
+		if err := r.deleteOwnedSecret(ctx, authrealm, name, clusterName); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func GetStrategyFromPlacementDecision(c client.Client, placementDecisionName, placementDecisionNamespace string) (*identitatemv1alpha1.Strategy, error) {
-	//Placement decisions has the same name then PlacementDecision
-	//and so we can search the placement from placement decsion
-	return GetStrategyFromPlacement(c, placementDecisionName, placementDecisionNamespace)
+// deleteOwnedSecret deletes the secret if it belongs to the authrealm
+func (r *PlacementDecisionReconciler) deleteOwnedSecret(ctx context.Context, authrealm *identitatemv1alpha1.AuthRealm,
+	name, namespace string) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !controllershelpers.IsOwnedByAuthRealm(secret, authrealm) {
+		return nil
+	}
+	if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// legacyClientSecretName returns the name of the client secrets generated before the names ended with a hash,
+// these names could collide across authrealms and so are only deleted if owned by the authrealm
+func legacyClientSecretName(authrealm *identitatemv1alpha1.AuthRealm, idpName string) string {
+	return fmt.Sprintf("%s-%s-%s", authrealm.Namespace, authrealm.Name, idpName)
 }
 
-func GetStrategyFromPlacement(c client.Client, placementName, placementNamespace string) (*identitatemv1alpha1.Strategy, error) {
-	strategies := &identitatemv1alpha1.StrategyList{}
-	if err := c.List(context.TODO(), strategies, &client.ListOptions{Namespace: placementNamespace}); err != nil {
+const (
+	// PlacementLabel is set by the placement controller on each PlacementDecision
+	// and points to the Placement the decision belongs to
+	PlacementLabel string = "cluster.open-cluster-management.io/placement"
+)
+
+// GetPlacementFromPlacementDecision returns the Placement referenced by the placement label
+// of the PlacementDecision. A Placement can have several PlacementDecisions
+// named <placement>-decision-<n>, so the name of the decision can not be used.
+func GetPlacementFromPlacementDecision(ctx context.Context, c client.Client, placementDecision *clusterv1alpha1.PlacementDecision) (*clusterv1alpha1.Placement, error) {
+	placementName, ok := placementDecision.GetLabels()[PlacementLabel]
+	if !ok || len(placementName) == 0 {
+		return nil, fmt.Errorf("placementDecision %s/%s has no label %s",
+			placementDecision.Namespace, placementDecision.Name, PlacementLabel)
+	}
+	placement := &clusterv1alpha1.Placement{}
+	if err := c.Get(ctx, client.ObjectKey{Name: placementName, Namespace: placementDecision.Namespace}, placement); err != nil {
 		return nil, err
 	}
-	for _, strategy := range strategies.Items {
-		if strategy.Spec.PlacementRef.Name == placementName {
-			return &strategy, nil
+	return placement, nil
+}
+
+func GetStrategyFromPlacementDecision(ctx context.Context, c client.Client, placementDecision *clusterv1alpha1.PlacementDecision) (*identitatemv1alpha1.Strategy, error) {
+	placementName, ok := placementDecision.GetLabels()[PlacementLabel]
+	if !ok || len(placementName) == 0 {
+		return nil, fmt.Errorf("placementDecision %s/%s has no label %s",
+			placementDecision.Namespace, placementDecision.Name, PlacementLabel)
+	}
+	return GetStrategyFromPlacement(ctx, c, placementName, placementDecision.Namespace)
+}
+
+// isOwnedByStrategy returns true if one of the ownerReferences of the object is a Strategy
+func isOwnedByStrategy(obj metav1.Object) bool {
+	_, ok := getOwnerStrategyName(obj)
+	return ok
+}
+
+// getOwnerStrategyName returns the name of the Strategy in the ownerReferences of the object
+func getOwnerStrategyName(obj metav1.Object) (string, bool) {
+	for _, or := range obj.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(or.APIVersion)
+		if err != nil {
+			continue
+		}
+		if gv.Group == identitatemv1alpha1.SchemeGroupVersion.Group && or.Kind == "Strategy" {
+			return or.Name, true
 		}
 	}
-	return nil, errors.NewNotFound(identitatemv1alpha1.Resource("strategies"), placementName)
-	// strategy := &identitatemv1alpha1.Strategy{}
-	// var ownerRef metav1.OwnerReference
-	// //DV not needed
-	// // placementInfo := &identitatemv1alpha1.Placement{}
+	return "", false
+}
 
-	// //for _, or := range ownerRefs {
-	// for _, or := range strategy.GetOwnerReferences() {
+// GetStrategyFromPlacement returns the Strategy which generated the Placement,
+// the Strategy is found through the label of the Placement or its owner reference.
+func GetStrategyFromPlacement(ctx context.Context, c client.Client, placementName, placementNamespace string) (*identitatemv1alpha1.Strategy, error) {
+	placement := &clusterv1alpha1.Placement{}
+	if err := c.Get(ctx, client.ObjectKey{Name: placementName, Namespace: placementNamespace}, placement); err != nil {
+		return nil, err
+	}
+	return getStrategyOfPlacement(ctx, c, placement)
+}
 
-	// 	//TODO find a better way
-	// 	if or.Kind == "Strategy" {
-	// 		ownerRef = or
-	// 		break
-	// 	}
-	// }
-	// if err := c.Get(context.TODO(), client.ObjectKey{Name: ownerRef.Name, Namespace: strategy.Namespace}, strategy); err != nil {
-	// 	return nil, err
-	// }
-	// return strategy, nil
+func getStrategyOfPlacement(ctx context.Context, c client.Client, placement *clusterv1alpha1.Placement) (*identitatemv1alpha1.Strategy, error) {
+	strategyName, ok := placement.GetLabels()[controllershelpers.StrategyLabel]
+	if !ok {
+		strategyName, _ = getOwnerStrategyName(placement)
+	}
+	if len(strategyName) == 0 {
+		return nil, errors.NewNotFound(identitatemv1alpha1.Resource("strategies"), placement.Name)
+	}
+	strategy := &identitatemv1alpha1.Strategy{}
+	if err := c.Get(ctx, client.ObjectKey{Name: strategyName, Namespace: placement.Namespace}, strategy); err != nil {
+		return nil, err
+	}
+	return strategy, nil
 }
 
-func inPlacementDecision(clusterName string, placementDecision *clusterv1alpha1.PlacementDecision) bool {
-	for _, decision := range placementDecision.Status.Decisions {
-		if decision.ClusterName == clusterName {
-			return true
+// GetPlacementDecisionClusters returns the clusters decided for a Placement.
+// The decisions can be split across several PlacementDecisions (100 clusters each)
+// so all PlacementDecisions of the Placement are read. The order of the pages
+// and of the decisions in each page is kept.
+func GetPlacementDecisionClusters(ctx context.Context, c client.Client, placement *clusterv1alpha1.Placement) ([]string, error) {
+	placementDecisions := &clusterv1alpha1.PlacementDecisionList{}
+	if err := c.List(ctx, placementDecisions,
+		client.InNamespace(placement.Namespace),
+		client.MatchingLabels{PlacementLabel: placement.Name}); err != nil {
+		return nil, err
+	}
+	items := placementDecisions.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		return placementDecisionIndex(&items[i]) < placementDecisionIndex(&items[j])
+	})
+	clusters := make([]string, 0)
+	seen := sets.NewString()
+	for _, placementDecision := range items {
+		for _, decision := range placementDecision.Status.Decisions {
+			if len(decision.ClusterName) == 0 || seen.Has(decision.ClusterName) {
+				continue
+			}
+			seen.Insert(decision.ClusterName)
+			clusters = append(clusters, decision.ClusterName)
 		}
 	}
-	return false
+	return clusters, nil
+}
+
+// placementDecisionIndex returns the page index of a PlacementDecision named <placement>-decision-<n>
+func placementDecisionIndex(placementDecision *clusterv1alpha1.PlacementDecision) int {
+	name := placementDecision.GetName()
+	index, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return 0
+	}
+	return index
 }
@@ -0,0 +1,177 @@
+// Copyright Red Hat
+
+package placementdecision
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// WaitingCondition is set on the Strategy while the DexServer of its AuthRealm is not ready,
+	// the message explains what is missing.
+	WaitingCondition string = "Waiting"
+	// DexServerNotReadyReason is the reason of the Waiting condition while the DexServer is not ready
+	DexServerNotReadyReason string = "DexServerNotReady"
+	// DexNamespaceConflictReason is the reason of the Waiting condition while the Dex namespace
+	// of the AuthRealm is owned by another AuthRealm with the same name
+	DexNamespaceConflictReason string = "DexNamespaceConflict"
+
+	// dexServerMinBackoff and dexServerMaxBackoff bound the delay before checking again a DexServer which is not ready
+	dexServerMinBackoff = 5 * time.Second
+	dexServerMaxBackoff = 5 * time.Minute
+)
+
+// getDexServerReadiness returns what is missing for the DexServer of the AuthRealm to serve the DexClients, nil if ready.
+// The DexServer is ready once it publishes its issuer URL, it has an available Deployment
+// and a Route admitted for the host of the issuer.
+func (r *PlacementDecisionReconciler) getDexServerReadiness(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm) ([]string, error) {
+	dexNamespace := controllershelpers.DexNamespace(authrealm)
+	ns := &corev1.Namespace{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: dexNamespace}, ns)
+	switch {
+	case errors.IsNotFound(err):
+		return []string{fmt.Sprintf("namespace %s not found", dexNamespace)}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	dexServer := &identitatemdexv1alpha1.DexServer{}
+	err = r.Client.Get(ctx, client.ObjectKey{Name: controllershelpers.DexServerName(authrealm), Namespace: dexNamespace}, dexServer)
+	switch {
+	case errors.IsNotFound(err):
+		return []string{fmt.Sprintf("dexserver %s/%s not found", dexNamespace, controllershelpers.DexServerName(authrealm))}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	missing := make([]string, 0)
+
+	deployed, err := r.isDexServerDeployed(ctx, dexServer)
+	if err != nil {
+		return nil, err
+	}
+	if !deployed {
+		missing = append(missing, fmt.Sprintf("dexserver %s/%s not deployed", dexServer.Namespace, dexServer.Name))
+	}
+
+	if len(dexServer.Spec.Issuer) == 0 {
+		return append(missing, fmt.Sprintf("dexserver %s/%s has no issuer URL", dexServer.Namespace, dexServer.Name)), nil
+	}
+	issuer, err := url.Parse(dexServer.Spec.Issuer)
+	if err != nil || len(issuer.Hostname()) == 0 {
+		return append(missing, fmt.Sprintf("dexserver %s/%s has an invalid issuer URL %s",
+			dexServer.Namespace, dexServer.Name, dexServer.Spec.Issuer)), nil
+	}
+	admitted, err := r.isRouteAdmitted(ctx, dexServer.Namespace, issuer.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if !admitted {
+		missing = append(missing, fmt.Sprintf("no route admitted for host %s in namespace %s", issuer.Hostname(), dexServer.Namespace))
+	}
+
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return missing, nil
+}
+
+// isDexServerDeployed returns true if a Deployment owned by the DexServer has an available replica
+func (r *PlacementDecisionReconciler) isDexServerDeployed(ctx context.Context,
+	dexServer *identitatemdexv1alpha1.DexServer) (bool, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := r.apiReader().List(ctx, deployments, client.InNamespace(dexServer.Namespace)); err != nil {
+		return false, err
+	}
+	for _, deployment := range deployments.Items {
+		for _, ownerRef := range deployment.GetOwnerReferences() {
+			if ownerRef.UID == dexServer.UID && deployment.Status.AvailableReplicas > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isRouteAdmitted returns true if a Route of the namespace for the host is admitted by a router
+func (r *PlacementDecisionReconciler) isRouteAdmitted(ctx context.Context, namespace, host string) (bool, error) {
+	routes := &routev1.RouteList{}
+	if err := r.apiReader().List(ctx, routes, client.InNamespace(namespace)); err != nil {
+		return false, err
+	}
+	for _, route := range routes.Items {
+		if route.Spec.Host != host {
+			continue
+		}
+		for _, ingress := range route.Status.Ingress {
+			for _, condition := range ingress.Conditions {
+				if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// apiReader returns the reader of the objects which are not cached
+func (r *PlacementDecisionReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// dexServerBackoff returns the delay before checking again the DexServer, it doubles the time
+// the strategy has been waiting for so far, within dexServerMinBackoff and dexServerMaxBackoff
+func dexServerBackoff(strategy *identitatemv1alpha1.Strategy, now time.Time) time.Duration {
+	condition := meta.FindStatusCondition(strategy.Status.Conditions, WaitingCondition)
+	if condition == nil || condition.Status != metav1.ConditionTrue {
+		return dexServerMinBackoff
+	}
+	backoff := now.Sub(condition.LastTransitionTime.Time)
+	switch {
+	case backoff < dexServerMinBackoff:
+		return dexServerMinBackoff
+	case backoff > dexServerMaxBackoff:
+		return dexServerMaxBackoff
+	}
+	return backoff
+}
+
+// setWaitingCondition reports on the strategy what is missing for the DexServer to be ready,
+// the reason tells why the DexServer can't serve the DexClients if something is missing
+func (r *PlacementDecisionReconciler) setWaitingCondition(ctx context.Context, strategy *identitatemv1alpha1.Strategy,
+	reason string,
+	missing []string) error {
+	condition := metav1.Condition{
+		Type:    WaitingCondition,
+		Status:  metav1.ConditionFalse,
+		Reason:  "DexServerReady",
+		Message: "the dexserver of the authrealm is ready",
+	}
+	if len(missing) != 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason
+		condition.Message = strings.Join(missing, "; ")
+	}
+	return controllershelpers.SetStrategyCondition(ctx, r.Client, strategy, condition)
+}
@@ -0,0 +1,258 @@
+// Copyright Red Hat
+
+package placementdecision
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// GroupSyncSourceAnnotation enables the group synchronization on a Strategy, the value is the name
+	// of the ConfigMap of the Strategy namespace listing the groups in its groups.yaml key:
+	// each group has a name and the list of its users.
+	GroupSyncSourceAnnotation string = "identityconfig.identitatem.io/group-sync-source"
+
+	// GroupSyncReadyCondition is set on the Strategy while the group synchronization is enabled,
+	// the message references the source of the groups.
+	GroupSyncReadyCondition string = "GroupSyncReady"
+)
+
+// groupSync is the group synchronization of a Strategy
+type groupSync struct {
+	// enabled is true if the Strategy has a group sync source
+	enabled bool
+	// source is the ConfigMap listing the groups, nil if not found
+	source *corev1.ConfigMap
+	// groups are the groups of the source, nil if the source is not found or invalid
+	groups []controllershelpers.Group
+	// invalid is the error reading the groups of the source
+	invalid error
+}
+
+// getGroupSync returns the group synchronization of the strategy
+func (r *PlacementDecisionReconciler) getGroupSync(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy) (*groupSync, error) {
+	name, ok := strategy.GetAnnotations()[GroupSyncSourceAnnotation]
+	if !ok || len(name) == 0 {
+		return &groupSync{}, nil
+	}
+	sync := &groupSync{enabled: true}
+	source := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: strategy.Namespace}, source)
+	switch {
+	case errors.IsNotFound(err):
+		return sync, nil
+	case err != nil:
+		return nil, err
+	}
+	sync.source = source
+	sync.groups, sync.invalid = controllershelpers.ParseGroups(source)
+	return sync, nil
+}
+
+// newGroupsConfigMap returns the copy of the groups in the cluster namespace
+func newGroupsConfigMap(authrealm *identitatemv1alpha1.AuthRealm,
+	ownerLabels map[string]string,
+	clusterName string,
+	groups []controllershelpers.Group) (*corev1.ConfigMap, error) {
+	b, err := yaml.Marshal(groups)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        controllershelpers.GroupsConfigMapName(authrealm),
+			Namespace:   clusterName,
+			Labels:      map[string]string{controllershelpers.GroupSyncLabel: ""},
+			Annotations: controllershelpers.OwnerAnnotations(authrealm),
+		},
+		Data: map[string]string{
+			controllershelpers.GroupsKey: string(b),
+		},
+	}
+	for k, v := range ownerLabels {
+		cm.Labels[k] = v
+	}
+	return cm, nil
+}
+
+// syncGroups copies the groups of the group sync source of the strategy in the namespace of the clusters
+// updated by the rollout, the ClusterOAuth controller delivers them to the clusters. The groups are part
+// of the rollout revision so their changes follow the rollout policy of the strategy.
+// The copies of the clusters which are no longer decided are deleted, as well as all copies once
+// the group synchronization is disabled. The copies are kept while the source is not found or invalid,
+// the GroupSyncReady condition reports it.
+func (r *PlacementDecisionReconciler) syncGroups(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters []string,
+	updatedClusters []string,
+	sync *groupSync) error {
+	switch {
+	case sync.enabled && sync.source == nil:
+		return r.setGroupSyncReadyCondition(ctx, strategy, metav1.ConditionFalse, "SourceNotFound",
+			fmt.Sprintf("configmap %s/%s not found, the groups delivered to the clusters are kept",
+				strategy.Namespace, strategy.GetAnnotations()[GroupSyncSourceAnnotation]))
+	case sync.invalid != nil:
+		return r.setGroupSyncReadyCondition(ctx, strategy, metav1.ConditionFalse, "InvalidSource",
+			fmt.Sprintf("%v, the groups delivered to the clusters are kept", sync.invalid))
+	}
+
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	keep := sets.NewString()
+	if sync.enabled {
+		keep.Insert(clusters...)
+	}
+
+	existing := &corev1.ConfigMapList{}
+	if err := r.Client.List(ctx, existing,
+		client.MatchingLabels(ownerLabels),
+		client.HasLabels{controllershelpers.GroupSyncLabel}); err != nil {
+		return err
+	}
+	for i := range existing.Items {
+		if keep.Has(existing.Items[i].Namespace) &&
+			existing.Items[i].Name == controllershelpers.GroupsConfigMapName(authrealm) {
+			continue
+		}
+		if err := r.Client.Delete(ctx, &existing.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if !sync.enabled {
+		return controllershelpers.RemoveStrategyCondition(ctx, r.Client, strategy, GroupSyncReadyCondition)
+	}
+
+	for _, clusterName := range updatedClusters {
+		cm := &corev1.ConfigMap{}
+		err := r.Client.Get(ctx,
+			client.ObjectKey{Name: controllershelpers.GroupsConfigMapName(authrealm), Namespace: clusterName}, cm)
+		switch {
+		case err == nil:
+			if !controllershelpers.IsOwnedByAuthRealm(cm, authrealm) {
+				return &controllershelpers.CollisionError{
+					Kind:      "ConfigMap",
+					Name:      cm.Name,
+					Namespace: cm.Namespace,
+					AuthRealm: authrealm,
+				}
+			}
+		case !errors.IsNotFound(err):
+			return err
+		}
+		cm, err = newGroupsConfigMap(authrealm, ownerLabels, clusterName, sync.groups)
+		if err != nil {
+			return err
+		}
+		if err := controllershelpers.Apply(ctx, r.Client, cm, controllershelpers.PlacementDecisionFieldManager); err != nil {
+			return err
+		}
+	}
+	return r.setGroupSyncReadyCondition(ctx, strategy, metav1.ConditionTrue, "Synced",
+		fmt.Sprintf("%d groups of configmap %s/%s synced to %d of %d clusters",
+			len(sync.groups), sync.source.Namespace, sync.source.Name, len(updatedClusters), len(clusters)))
+}
+
+// previewGroups returns the group ConfigMaps syncGroups would generate for the clusters
+func (r *PlacementDecisionReconciler) previewGroups(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	placement *clusterv1alpha1.Placement,
+	clusters []string) ([]*corev1.ConfigMap, error) {
+	cms := make([]*corev1.ConfigMap, 0)
+	sync, err := r.getGroupSync(ctx, strategy)
+	if err != nil || sync.groups == nil {
+		return cms, err
+	}
+	ownerLabels := controllershelpers.OwnerLabels(authrealm, strategy.Name, placement.Name)
+	for _, clusterName := range clusters {
+		cm, err := newGroupsConfigMap(authrealm, ownerLabels, clusterName, sync.groups)
+		if err != nil {
+			return nil, err
+		}
+		cms = append(cms, cm)
+	}
+	return cms, nil
+}
+
+// setGroupSyncReadyCondition reports on the strategy the synchronization of the groups
+func (r *PlacementDecisionReconciler) setGroupSyncReadyCondition(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	status metav1.ConditionStatus,
+	reason, message string) error {
+	return controllershelpers.SetStrategyCondition(ctx, r.Client, strategy, metav1.Condition{
+		Type:    GroupSyncReadyCondition,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// groupSyncSourceRequest maps a ConfigMap to the reconcile requests of the Placements
+// of the Strategies which use it as group sync source. A copy of groups in a cluster namespace
+// maps to the Placement it is generated for so a modified copy is restored.
+func (r *PlacementDecisionReconciler) groupSyncSourceRequest(o client.Object) []reconcile.Request {
+	if _, ok := o.GetLabels()[controllershelpers.GroupSyncLabel]; ok {
+		return groupsConfigMapRequest(o)
+	}
+	strategies := &identitatemv1alpha1.StrategyList{}
+	if err := r.Client.List(context.TODO(), strategies, client.InNamespace(o.GetNamespace())); err != nil {
+		r.Log.Error(err, "Error while listing the strategies", "namespace", o.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, strategy := range strategies.Items {
+		if strategy.GetAnnotations()[GroupSyncSourceAnnotation] != o.GetName() {
+			continue
+		}
+		placements := &clusterv1alpha1.PlacementList{}
+		if err := r.Client.List(context.TODO(), placements, client.InNamespace(o.GetNamespace()),
+			client.MatchingLabels{controllershelpers.StrategyLabel: strategy.Name}); err != nil {
+			r.Log.Error(err, "Error while listing the placements", "strategy", strategy.Name)
+			continue
+		}
+		for _, placement := range placements.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: placement.Name, Namespace: placement.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// groupsConfigMapRequest maps a copy of groups to the reconcile request of its Placement
+func groupsConfigMapRequest(o client.Object) []reconcile.Request {
+	labels := o.GetLabels()
+	if len(labels[controllershelpers.PlacementNameLabel]) == 0 || len(labels[controllershelpers.AuthRealmNamespaceLabel]) == 0 {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Name:      labels[controllershelpers.PlacementNameLabel],
+				Namespace: labels[controllershelpers.AuthRealmNamespaceLabel],
+			},
+		},
+	}
+}
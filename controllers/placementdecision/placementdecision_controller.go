@@ -5,10 +5,11 @@ package placementdecision
 import (
 	"context"
 	"fmt"
+	"text/template"
 	"time"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
-	// corev1 "k8s.io/api/core/v1"
+	routev1 "github.com/openshift/api/route/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -18,7 +19,11 @@ import (
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/go-logr/logr"
 	dexoperatorv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
@@ -41,18 +46,35 @@ type PlacementDecisionReconciler struct {
 	APIExtensionClient apiextensionsclient.Interface
 	Log                logr.Logger
 	Scheme             *runtime.Scheme
+	// APIReader reads the Deployments and Routes of the Dex namespaces from the API server,
+	// so no informer is started for all the Deployments and Routes of the hub. The Client is used if nil.
+	APIReader client.Reader
+	// ReconcileTimeout is the deadline of a reconcile, no deadline if zero
+	ReconcileTimeout time.Duration
+	// ControllerOptions sets the concurrency and the rate limiter of the controller
+	ControllerOptions controller.Options
+	// DefaultStrategy is the type of the Strategies which don't set one
+	DefaultStrategy identitatemv1alpha1.StrategyType
+	// RedirectTemplate renders the redirect URI of the DexClients, DefaultRedirectTemplate if nil
+	RedirectTemplate *template.Template
+	// RotationPeriod is the maximum age of the client secrets, they are never rotated if zero
+	RotationPeriod time.Duration
 }
 
-// +kubebuilder:rbac:groups="",resources={namespaces,secrets},verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources={namespaces,secrets,configmaps},verbs=get;list;watch;create;update;patch;delete
 
-//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={authrealms,strategies},verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={authrealms,strategies,clusteroauths},verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/finalizers,verbs=update
 //+kubebuilder:rbac:groups=auth.identitatem.io,resources={dexclients},verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list;create;update;patch;delete
+// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list
 
 //+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources={managedclusters,placements,placementdecisions},verbs=get;list;watch;create;update;patch;delete;watch
+//+kubebuilder:rbac:groups=work.open-cluster-management.io,resources={manifestworks},verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=config.openshift.io,resources={infrastructures},verbs=get;list;watch;create;update;patch;delete;watch
+//+kubebuilder:rbac:groups=auth.identitatem.io,resources={dexservers},verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources={deployments},verbs=get;list;watch
+//+kubebuilder:rbac:groups=route.openshift.io,resources={routes},verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,17 +86,21 @@ type PlacementDecisionReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *PlacementDecisionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
-	_ = r.Log.WithValues("placementDecision", req.NamespacedName)
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
+	_ = r.Log.WithValues("placement", req.NamespacedName)
 
-	// your logic here
-	// Fetch the ManagedCluster instance
-	instance := &clusterv1alpha1.PlacementDecision{}
+	// The reconciles are keyed by Placement as the decisions of a Placement can be split
+	// across several PlacementDecisions which must not be reconciled by 2 workers at the same time.
+	placement := &clusterv1alpha1.Placement{}
 
 	if err := r.Client.Get(
-		context.TODO(),
+		ctx,
 		types.NamespacedName{Namespace: req.Namespace, Name: req.Name},
-		instance,
+		placement,
 	); err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
@@ -86,27 +112,17 @@ func (r *PlacementDecisionReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return reconcile.Result{}, err
 	}
 
-	r.Log.Info("Instance", "instance", instance)
-	r.Log.Info("Running Reconcile for PlacementDecision.", "Name: ", instance.GetName(), " Namespace:", instance.GetNamespace())
-
-	//Search the placement corresponding to the placementDecision
-	placement := &clusterv1alpha1.Placement{}
-	err := r.Get(context.TODO(),
-		client.ObjectKey{
-			Name:      instance.GetName(),
-			Namespace: instance.GetNamespace(),
-		}, placement)
-	if err != nil {
-		return reconcile.Result{}, err
-	}
+	r.Log.Info("Running Reconcile for the PlacementDecisions of Placement.", "Name: ", placement.GetName(), " Namespace:", placement.GetNamespace())
 
-	strategy, err := GetStrategyFromPlacementDecision(r.Client, instance.Name, instance.Namespace)
+	strategy, err := getStrategyOfPlacement(ctx, r.Client, placement)
 	if err != nil {
 		r.Log.Error(err, "Error while getting the strategy")
 		return reconcile.Result{}, err
 	}
 
-	authrealm, err := helpers.GetAuthrealmFromStrategy(r.Client, strategy)
+	helpers.SetDefaultStrategyType(strategy, r.DefaultStrategy)
+
+	authrealm, err := helpers.GetAuthrealmFromStrategy(ctx, r.Client, strategy)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -115,15 +131,54 @@ func (r *PlacementDecisionReconciler) Reconcile(ctx context.Context, req ctrl.Re
 
 	switch strategy.Spec.Type {
 	case identitatemv1alpha1.BackplaneStrategyType:
-		//check if dex server installed
-		ns := &corev1.Namespace{}
-		if err := r.Get(context.TODO(), client.ObjectKey{Name: authrealm.Name}, ns); err != nil {
-			return reconcile.Result{Requeue: true, RequeueAfter: 10 * time.Second}, err
+		clusters, err := GetPlacementDecisionClusters(ctx, r.Client, placement)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		if helpers.IsDryRun(strategy) {
+			if err := r.backplaneStrategyDryRun(ctx, strategy, authrealm, placement, clusters); err != nil {
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{}, nil
+		}
+
+		//Wait for the DexServer to be ready before syncing the DexClients, the retries back off
+		//without returning an error as waiting for the DexServer is expected.
+		//The DexClients are not generated in a Dex namespace owned by another AuthRealm.
+		reason := DexNamespaceConflictReason
+		conflict, err := helpers.GetDexNamespaceConflict(ctx, r.Client, authrealm)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		missing := []string{conflict}
+		if len(conflict) != 0 {
+			if err := r.deleteDexClients(ctx, authrealm); err != nil {
+				return reconcile.Result{}, err
+			}
+		} else {
+			reason = DexServerNotReadyReason
+			if missing, err = r.getDexServerReadiness(ctx, authrealm); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if len(missing) != 0 {
+			backoff := dexServerBackoff(strategy, time.Now())
+			r.Log.Info("Waiting for the DexServer", "strategy", strategy.Name, "missing", missing, "retry", backoff)
+			if err := r.setWaitingCondition(ctx, strategy, reason, missing); err != nil {
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{RequeueAfter: backoff}, nil
+		}
+		if err := r.setWaitingCondition(ctx, strategy, "", nil); err != nil {
+			return reconcile.Result{}, err
 		}
 
-		if err := r.backplaneStrategy(authrealm, placement, instance); err != nil {
+		result, err := r.backplaneStrategy(ctx, strategy, authrealm, placement, clusters)
+		if err != nil {
 			return reconcile.Result{}, err
 		}
+		return result, nil
 	// case identitatemv1alpha1.GrcStrategyType:
 	// 	if err := r.grcStrategy(placement, instance); err != nil {
 	// 		return reconcile.Result{}, err
@@ -131,8 +186,6 @@ func (r *PlacementDecisionReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	default:
 		return reconcile.Result{}, fmt.Errorf("strategy type %s not supported", strategy.Spec.Type)
 	}
-
-	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -162,7 +215,117 @@ func (r *PlacementDecisionReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1alpha1.PlacementDecision{}).
-		Complete(r)
+	if err := routev1.AddToScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+
+	options := r.ControllerOptions
+	options.Reconciler = r
+	c, err := controller.New("placementdecision", mgr, options)
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.ConfigMap{}},
+		handler.EnqueueRequestsFromMapFunc(r.groupSyncSourceRequest)); err != nil {
+		return err
+	}
+	//The changes of the spec and annotations of the AuthRealms, Strategies and DexServers start a rollout,
+	//the status updates of the Strategies by the controllers are ignored
+	specOrAnnotationsChanged := predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+	if err := c.Watch(&source.Kind{Type: &identitatemv1alpha1.AuthRealm{}},
+		handler.EnqueueRequestsFromMapFunc(r.authRealmPlacementsRequest), specOrAnnotationsChanged); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &identitatemv1alpha1.Strategy{}},
+		handler.EnqueueRequestsFromMapFunc(r.strategyPlacementsRequest), specOrAnnotationsChanged); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &dexoperatorv1alpha1.DexServer{}},
+		handler.EnqueueRequestsFromMapFunc(r.dexServerPlacementsRequest), specOrAnnotationsChanged); err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &clusterv1alpha1.PlacementDecision{}},
+		handler.EnqueueRequestsFromMapFunc(placementRequest),
+		r.strategyPlacementDecisionPredicate())
+}
+
+// placementRequest maps a PlacementDecision to the reconcile request of its Placement
+func placementRequest(o client.Object) []reconcile.Request {
+	placementName, ok := o.GetLabels()[PlacementLabel]
+	if !ok || len(placementName) == 0 {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: placementName, Namespace: o.GetNamespace()}},
+	}
+}
+
+// strategyPlacementsRequest maps a Strategy to the reconcile requests of its Placements
+func (r *PlacementDecisionReconciler) strategyPlacementsRequest(o client.Object) []reconcile.Request {
+	placements := &clusterv1alpha1.PlacementList{}
+	if err := r.Client.List(context.TODO(), placements, client.InNamespace(o.GetNamespace()),
+		client.MatchingLabels{helpers.StrategyLabel: o.GetName()}); err != nil {
+		r.Log.Error(err, "Error while listing the placements", "strategy", o.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, placement := range placements.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: placement.Name, Namespace: placement.Namespace},
+		})
+	}
+	return requests
+}
+
+// authRealmPlacementsRequest maps an AuthRealm to the reconcile requests of the Placements of its Strategies
+func (r *PlacementDecisionReconciler) authRealmPlacementsRequest(o client.Object) []reconcile.Request {
+	strategies := &identitatemv1alpha1.StrategyList{}
+	if err := r.Client.List(context.TODO(), strategies, client.InNamespace(o.GetNamespace())); err != nil {
+		r.Log.Error(err, "Error while listing the strategies", "namespace", o.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range strategies.Items {
+		for _, ownerRef := range strategies.Items[i].GetOwnerReferences() {
+			if ownerRef.Kind == "AuthRealm" && ownerRef.Name == o.GetName() {
+				requests = append(requests, r.strategyPlacementsRequest(&strategies.Items[i])...)
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// dexServerPlacementsRequest maps a DexServer to the reconcile requests of the Placements
+// of the Strategies of the AuthRealm it serves
+func (r *PlacementDecisionReconciler) dexServerPlacementsRequest(o client.Object) []reconcile.Request {
+	authrealms := &identitatemv1alpha1.AuthRealmList{}
+	if err := r.Client.List(context.TODO(), authrealms); err != nil {
+		r.Log.Error(err, "Error while listing the authrealms")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range authrealms.Items {
+		authrealm := &authrealms.Items[i]
+		if helpers.DexNamespace(authrealm) == o.GetNamespace() && helpers.DexServerName(authrealm) == o.GetName() {
+			requests = append(requests, r.authRealmPlacementsRequest(authrealm)...)
+		}
+	}
+	return requests
+}
+
+// strategyPlacementDecisionPredicate filters out the PlacementDecisions
+// which belong to a Placement not generated for a Strategy
+func (r *PlacementDecisionReconciler) strategyPlacementDecisionPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		placementDecision, ok := obj.(*clusterv1alpha1.PlacementDecision)
+		if !ok {
+			return false
+		}
+		placement, err := GetPlacementFromPlacementDecision(context.TODO(), r.Client, placementDecision)
+		if err != nil {
+			return false
+		}
+		return isOwnedByStrategy(placement)
+	})
 }
@@ -0,0 +1,276 @@
+// Copyright Red Hat
+
+package placementdecision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	"github.com/identitatem/idp-strategy-operator/controllers/clusteroauth"
+	controllershelpers "github.com/identitatem/idp-strategy-operator/controllers/helpers"
+	"github.com/identitatem/idp-strategy-operator/pkg/manifestwork"
+)
+
+const (
+	// RolloutBatchSizeAnnotation enables the progressive rollout on a Strategy,
+	// the value is a number of clusters ("10") or a percentage of the decided clusters ("20%")
+	RolloutBatchSizeAnnotation string = "identityconfig.identitatem.io/rollout-batch-size"
+	// RolloutPauseAnnotation is the minimum duration between 2 batches ("5m")
+	RolloutPauseAnnotation string = "identityconfig.identitatem.io/rollout-pause"
+	// RolloutMaxFailuresAnnotation is the number of failed clusters halting the rollout, default 1
+	RolloutMaxFailuresAnnotation string = "identityconfig.identitatem.io/rollout-max-failures"
+
+	// RolloutRevisionAnnotation is set on the ClusterOAuth of the Strategy with the revision synced to the cluster,
+	// each Strategy records its rollout on its own ClusterOAuth as the ManifestWork is shared by the AuthRealms of the cluster
+	RolloutRevisionAnnotation string = clusteroauth.RolloutRevisionAnnotation
+	// RolloutTimestampAnnotation is set on the ClusterOAuth of the Strategy when the revision is synced to the cluster
+	RolloutTimestampAnnotation string = clusteroauth.RolloutTimestampAnnotation
+
+	// RolloutProgressingCondition is set on the Strategy while a rollout policy is defined
+	RolloutProgressingCondition string = "RolloutProgressing"
+
+	rolloutRequeueAfter = 30 * time.Second
+)
+
+type rolloutPolicy struct {
+	batchSize   intstr.IntOrString
+	pause       time.Duration
+	maxFailures int
+}
+
+type clusterRolloutState struct {
+	revision  string
+	timestamp time.Time
+	available bool
+	failed    bool
+}
+
+type rolloutPlan struct {
+	// clusters are the clusters to sync at the current revision
+	clusters     []string
+	updated      int
+	failed       []string
+	halted       bool
+	paused       bool
+	completed    bool
+	requeueAfter time.Duration
+}
+
+// getRolloutPolicy returns the rollout policy of the strategy, nil if the strategy
+// doesn't define one and so all clusters are updated at once
+func getRolloutPolicy(strategy *identitatemv1alpha1.Strategy) (*rolloutPolicy, error) {
+	annotations := strategy.GetAnnotations()
+	batchSize, ok := annotations[RolloutBatchSizeAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	policy := &rolloutPolicy{
+		batchSize:   intstr.Parse(batchSize),
+		maxFailures: 1,
+	}
+	if pause, ok := annotations[RolloutPauseAnnotation]; ok {
+		d, err := time.ParseDuration(pause)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %v", RolloutPauseAnnotation, err)
+		}
+		policy.pause = d
+	}
+	if maxFailures, ok := annotations[RolloutMaxFailuresAnnotation]; ok {
+		n, err := strconv.Atoi(maxFailures)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid annotation %s: %s", RolloutMaxFailuresAnnotation, maxFailures)
+		}
+		policy.maxFailures = n
+	}
+	return policy, nil
+}
+
+// getRolloutRevision returns the revision of the configuration to deliver to the clusters,
+// the groups are the synchronized groups of the strategy, nil if none
+func getRolloutRevision(authrealm *identitatemv1alpha1.AuthRealm, groups []controllershelpers.Group) (string, error) {
+	var revision interface{} = authrealm.Spec
+	//The claims and the groups are only part of the revision when configured
+	//so the revision of the other authrealms doesn't change
+	claims := getClaimsAnnotations(authrealm)
+	if len(claims) != 0 || groups != nil {
+		configuration := map[string]interface{}{
+			"spec": authrealm.Spec,
+		}
+		if len(claims) != 0 {
+			configuration["claims"] = claims
+		}
+		if groups != nil {
+			configuration["groups"] = groups
+		}
+		revision = configuration
+	}
+	b, err := json.Marshal(revision)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+// getClusterRolloutStates reads the rollout state of each cluster from the ClusterOAuth of the strategy.
+// A cluster is available or failed only once the ManifestWork applies the manifests including
+// the revision of the ClusterOAuth, the conditions of the previous manifests are ignored.
+func (r *PlacementDecisionReconciler) getClusterRolloutStates(ctx context.Context,
+	strategy *identitatemv1alpha1.Strategy,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	clusters []string) (map[string]clusterRolloutState, error) {
+	states := make(map[string]clusterRolloutState)
+	for _, clusterName := range clusters {
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+		err := r.Client.Get(ctx,
+			client.ObjectKey{Name: controllershelpers.ClusterOAuthName(authrealm, strategy.Spec.Type), Namespace: clusterName},
+			clusterOAuth)
+		switch {
+		case errors.IsNotFound(err):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		if !controllershelpers.IsOwnedByAuthRealm(clusterOAuth, authrealm) {
+			continue
+		}
+		annotations := clusterOAuth.GetAnnotations()
+		state := clusterRolloutState{
+			revision: annotations[RolloutRevisionAnnotation],
+		}
+		if t, err := time.Parse(time.RFC3339, annotations[RolloutTimestampAnnotation]); err == nil {
+			state.timestamp = t
+		}
+		states[clusterName] = state
+
+		if annotations[clusteroauth.DeliveredRolloutRevisionAnnotation] != state.revision {
+			continue
+		}
+		mw, err := manifestwork.Get(ctx, r.Client, client.ObjectKey{Name: BackplaneManifestWorkName, Namespace: clusterName})
+		if err != nil {
+			return nil, err
+		}
+		if mw == nil {
+			continue
+		}
+		manifestsRevision := annotations[clusteroauth.DeliveredManifestsRevisionAnnotation]
+		switch {
+		case mw.GetAnnotations()[clusteroauth.RolledBackRevisionAnnotation] == manifestsRevision:
+			state.failed = true
+		case mw.GetAnnotations()[clusteroauth.ManifestsRevisionAnnotation] == manifestsRevision:
+			appliedAt, err := time.Parse(time.RFC3339, mw.GetAnnotations()[clusteroauth.ManifestsAppliedAtAnnotation])
+			if err != nil {
+				appliedAt = mw.CreationTimestamp.Time
+			}
+			status := manifestwork.GetStatus(mw, appliedAt)
+			state.available = status.Available
+			state.failed = status.Failed
+		}
+		states[clusterName] = state
+	}
+	return states, nil
+}
+
+// planRollout returns the clusters which can be synced at the revision.
+// The clusters are rolled out in the order of the placement decisions, one batch at a time.
+// A batch starts when all clusters of the previous batches are available and the pause is over.
+// The rollout is halted when the number of failed clusters reaches the maximum of failures.
+func planRollout(policy *rolloutPolicy,
+	clusters []string,
+	states map[string]clusterRolloutState,
+	revision string,
+	now time.Time) *rolloutPlan {
+	plan := &rolloutPlan{
+		clusters: make([]string, 0),
+	}
+	if policy == nil {
+		plan.clusters = append(plan.clusters, clusters...)
+		plan.completed = true
+		return plan
+	}
+
+	pending := false
+	var lastBatch time.Time
+	notUpdated := make([]string, 0)
+	for _, clusterName := range clusters {
+		state, ok := states[clusterName]
+		if !ok || state.revision != revision {
+			notUpdated = append(notUpdated, clusterName)
+			continue
+		}
+		plan.clusters = append(plan.clusters, clusterName)
+		plan.updated++
+		switch {
+		case state.failed:
+			plan.failed = append(plan.failed, clusterName)
+		case !state.available:
+			pending = true
+		}
+		if state.timestamp.After(lastBatch) {
+			lastBatch = state.timestamp
+		}
+	}
+
+	switch {
+	case len(plan.failed) >= policy.maxFailures:
+		plan.halted = true
+		return plan
+	case len(notUpdated) == 0:
+		plan.completed = !pending
+		if pending {
+			plan.requeueAfter = rolloutRequeueAfter
+		}
+		return plan
+	case pending:
+		plan.requeueAfter = rolloutRequeueAfter
+		return plan
+	case now.Before(lastBatch.Add(policy.pause)):
+		plan.paused = true
+		plan.requeueAfter = lastBatch.Add(policy.pause).Sub(now)
+		return plan
+	}
+
+	batchSize, err := intstr.GetScaledValueFromIntOrPercent(&policy.batchSize, len(clusters), true)
+	if err != nil || batchSize < 1 {
+		batchSize = 1
+	}
+	if batchSize > len(notUpdated) {
+		batchSize = len(notUpdated)
+	}
+	plan.clusters = append(plan.clusters, notUpdated[:batchSize]...)
+	plan.requeueAfter = rolloutRequeueAfter
+	return plan
+}
+
+// setRolloutCondition reports the progress of the rollout on the strategy
+func (r *PlacementDecisionReconciler) setRolloutCondition(ctx context.Context, strategy *identitatemv1alpha1.Strategy,
+	plan *rolloutPlan,
+	total int) error {
+	condition := metav1.Condition{
+		Type:    RolloutProgressingCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "BatchInProgress",
+		Message: fmt.Sprintf("%d/%d clusters updated, %d failed", plan.updated, total, len(plan.failed)),
+	}
+	switch {
+	case plan.halted:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Halted"
+		condition.Message = fmt.Sprintf("%s, rollout halted on failed clusters %v", condition.Message, plan.failed)
+	case plan.completed:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Completed"
+	case plan.paused:
+		condition.Reason = "Paused"
+	}
+	return controllershelpers.SetStrategyCondition(ctx, r.Client, strategy, condition)
+}
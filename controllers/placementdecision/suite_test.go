@@ -4,17 +4,24 @@ package placementdecision
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -22,8 +29,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	dexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
 	dexoperatorconfig "github.com/identitatem/dex-operator/config"
@@ -31,12 +40,16 @@ import (
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 	idpconfig "github.com/identitatem/idp-client-api/config"
 	openshiftconfigv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
 	clientsetcluster "open-cluster-management.io/api/client/cluster/clientset/versioned"
 	clientsetwork "open-cluster-management.io/api/client/work/clientset/versioned"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	workv1 "open-cluster-management.io/api/work/v1"
 	clusteradmasset "open-cluster-management.io/clusteradm/pkg/helpers/asset"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/clusteroauth"
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -75,6 +88,9 @@ var _ = BeforeSuite(func() {
 	dexServerCRD, err := getCRD(readerDex, "crd/bases/auth.identitatem.io_dexservers.yaml")
 	Expect(err).Should(BeNil())
 
+	clusterOAuthCRD, err := getCRD(readerIDP, "crd/bases/identityconfig.identitatem.io_clusteroauths.yaml")
+	Expect(err).Should(BeNil())
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDs: []client.Object{
@@ -82,6 +98,7 @@ var _ = BeforeSuite(func() {
 			authrealmCRD,
 			dexClientCRD,
 			dexServerCRD,
+			clusterOAuthCRD,
 		},
 		CRDDirectoryPaths: []string{
 			//DV added this line and copyed the authrealms CRD
@@ -107,6 +124,9 @@ var _ = BeforeSuite(func() {
 	err = openshiftconfigv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = routev1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	cfg, err = testEnv.Start()
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
@@ -159,6 +179,7 @@ var _ = Describe("Process Strategy backplane: ", func() {
 	CertificatesSecretRef := "my-certs"
 	StrategyName := AuthRealmName + "-backplane"
 	PlacementStrategyName := StrategyName
+	PlacementDecisionName := PlacementStrategyName + "-decision-1"
 	// PlacementName := AuthRealmName
 	ClusterName := "my-cluster"
 	MyIDPName := "my-idp"
@@ -181,6 +202,7 @@ var _ = Describe("Process Strategy backplane: ", func() {
 			}
 			err := k8sClient.Create(context.TODO(), ns)
 			Expect(err).To(BeNil())
+			createReadyDexServer(AuthRealmNameSpace, AuthRealmName)
 		})
 		var placement *clusterv1alpha1.Placement
 		By("Creating the placement strategy", func() {
@@ -188,6 +210,9 @@ var _ = Describe("Process Strategy backplane: ", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      PlacementStrategyName,
 					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
 				},
 				Spec: clusterv1alpha1.PlacementSpec{
 					Predicates: []clusterv1alpha1.ClusterPredicate{
@@ -252,9 +277,6 @@ var _ = Describe("Process Strategy backplane: ", func() {
 				},
 				Spec: identitatemv1alpha1.StrategySpec{
 					Type: identitatemv1alpha1.BackplaneStrategyType,
-					PlacementRef: corev1.LocalObjectReference{
-						Name: placement.Name,
-					},
 				},
 			}
 			controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
@@ -265,8 +287,11 @@ var _ = Describe("Process Strategy backplane: ", func() {
 		By("Create Placement Decision CR", func() {
 			placementDecision := &clusterv1alpha1.PlacementDecision{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      PlacementStrategyName,
+					Name:      PlacementDecisionName,
 					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						PlacementLabel: placement.Name,
+					},
 				},
 			}
 			placementDecision, err := clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
@@ -298,15 +323,16 @@ var _ = Describe("Process Strategy backplane: ", func() {
 				Scheme: scheme.Scheme,
 			}
 			req := ctrl.Request{}
-			req.Name = strategy.Spec.PlacementRef.Name
+			req.Name = PlacementStrategyName
 			req.Namespace = AuthRealmNameSpace
 			_, err := r.Reconcile(context.TODO(), req)
 			Expect(err).To(BeNil())
 		})
-		dexClientName := fmt.Sprintf("%s-%s", ClusterName, MyIDPName)
+		dexClientName := helpers.DexClientName(authRealm, ClusterName, MyIDPName)
+		clientSecretName := helpers.ClientSecretName(authRealm, MyIDPName)
 		clientSecret := &corev1.Secret{}
-		By(fmt.Sprintf("Checking client secret %s", MyIDPName), func() {
-			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: MyIDPName, Namespace: ClusterName}, clientSecret)
+		By(fmt.Sprintf("Checking client secret %s", clientSecretName), func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: clientSecretName, Namespace: ClusterName}, clientSecret)
 			Expect(err).To(BeNil())
 		})
 		By(fmt.Sprintf("Checking DexClient %s", dexClientName), func() {
@@ -316,6 +342,46 @@ var _ = Describe("Process Strategy backplane: ", func() {
 			Expect(dexClient.Spec.ClientID).To(Equal(string(clientSecret.Data["client-id"])))
 			Expect(dexClient.Spec.ClientSecret).To(Equal(string(clientSecret.Data["client-secret"])))
 		})
+		clusterOAuthName := helpers.ClusterOAuthName(authRealm, identitatemv1alpha1.BackplaneStrategyType)
+		By(fmt.Sprintf("Checking ClusterOAuth %s", clusterOAuthName), func() {
+			clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: clusterOAuthName, Namespace: ClusterName}, clusterOAuth)
+			Expect(err).To(BeNil())
+			for k, v := range helpers.OwnerLabels(authRealm, StrategyName, PlacementStrategyName) {
+				Expect(clusterOAuth.GetLabels()).To(HaveKeyWithValue(k, v))
+			}
+			Expect(len(clusterOAuth.Spec.OAuth.Spec.IdentityProviders)).To(Equal(1))
+			idp := clusterOAuth.Spec.OAuth.Spec.IdentityProviders[0]
+			Expect(idp.Name).To(Equal(helpers.IdentityProviderName(authRealm, MyIDPName)))
+			Expect(idp.OpenID.ExtraAuthorizeParameters).To(HaveKeyWithValue("connector_id", MyIDPName))
+			Expect(idp.Type).To(Equal(openshiftconfigv1.IdentityProviderTypeOpenID))
+			Expect(idp.OpenID).ToNot(BeNil())
+			Expect(idp.OpenID.Issuer).To(Equal(fmt.Sprintf("https://%s.apps.example.com", helpers.DexServerName(authRealm))))
+			Expect(idp.OpenID.ClientID).To(Equal(string(clientSecret.Data["client-id"])))
+			Expect(idp.OpenID.ClientSecret.Name).To(Equal(clientSecretName))
+			Expect(idp.OpenID.ExtraAuthorizeParameters).To(HaveKeyWithValue("connector_id", MyIDPName))
+			Expect(idp.OpenID.Claims.PreferredUsername).To(Equal([]string{"preferred_username"}))
+			Expect(clusterOAuth.GetAnnotations()).To(HaveKey(RolloutRevisionAnnotation))
+			Expect(clientSecret.Data["clientSecret"]).To(Equal(clientSecret.Data["client-secret"]))
+		})
+		By("Checking the placementDecision predicate", func() {
+			r := &PlacementDecisionReconciler{
+				Client: k8sClient,
+				Log:    logf.Log,
+				Scheme: scheme.Scheme,
+			}
+			placementDecision := &clusterv1alpha1.PlacementDecision{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: PlacementDecisionName, Namespace: AuthRealmNameSpace}, placementDecision)
+			Expect(err).To(BeNil())
+			p := r.strategyPlacementDecisionPredicate()
+			Expect(p.Generic(event.GenericEvent{Object: placementDecision})).To(BeFalse())
+
+			controllerutil.SetOwnerReference(strategy, placement, scheme.Scheme)
+			placement, err = clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Update(context.TODO(), placement, metav1.UpdateOptions{})
+			Expect(err).To(BeNil())
+			Expect(p.Generic(event.GenericEvent{Object: placementDecision})).To(BeTrue())
+		})
 		// By("Checking manifestwork", func() {
 		// 	_, err := clientSetWork.WorkV1().ManifestWorks(ClusterName).Get(context.TODO(), BackplaneManifestWorkName, metav1.GetOptions{})
 		// 	Expect(err).To(BeNil())
@@ -324,14 +390,1364 @@ var _ = Describe("Process Strategy backplane: ", func() {
 	})
 })
 
-func getCRD(reader *clusteradmasset.ScenarioResourcesReader, file string) (*apiextensionsv1.CustomResourceDefinition, error) {
-	b, err := reader.Asset(file)
-	if err != nil {
-		return nil, err
+var _ = Describe("Process Strategy backplane with paginated PlacementDecisions: ", func() {
+	AuthRealmName := "my-authrealm-pages"
+	AuthRealmNameSpace := "my-authrealmns-pages"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+	MyIDPName := "my-idp"
+	// More than 100 clusters, the placement controller creates a page per 100 decisions
+	NumberOfClusters := 150
+	ClusterNames := make([]string, NumberOfClusters)
+	for i := range ClusterNames {
+		ClusterNames[i] = fmt.Sprintf("cluster-pages-%03d", i)
 	}
-	crd := &apiextensionsv1.CustomResourceDefinition{}
-	if err := yaml.Unmarshal(b, crd); err != nil {
-		return nil, err
+
+	It("process PlacementDecisions with more than 100 clusters", func() {
+		By(fmt.Sprintf("creation of User namespace %s and Dex namespace %s", AuthRealmNameSpace, AuthRealmName), func() {
+			for _, name := range []string{AuthRealmNameSpace, AuthRealmName} {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+			createReadyDexServer(AuthRealmNameSpace, AuthRealmName)
+		})
+		By("creation of the cluster namespaces", func() {
+			for _, clusterName := range ClusterNames {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: clusterName,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+		})
+		var placement *clusterv1alpha1.Placement
+		By("Creating the placement strategy", func() {
+			placement = &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
+				},
+			}
+			var err error
+			placement, err = clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Create(context.TODO(), placement, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		var authRealm *identitatemv1alpha1.AuthRealm
+		By("creating a AuthRealm CR", func() {
+			var err error
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+					IdentityProviders: []openshiftconfigv1.IdentityProvider{
+						{
+							Name:          MyIDPName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+								GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+									ClientID: "me",
+								},
+							},
+						},
+					},
+					PlacementRef: corev1.LocalObjectReference{
+						Name: placement.Name,
+					},
+				},
+			}
+			authRealm, err = clientSetMgmt.IdentityconfigV1alpha1().AuthRealms(AuthRealmNameSpace).Create(context.TODO(), authRealm, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		By("creating a Strategy CR", func() {
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StrategyName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			_, err := clientSetStrategy.IdentityconfigV1alpha1().Strategies(AuthRealmNameSpace).Create(context.TODO(), strategy, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		By("Create Placement Decision CRs", func() {
+			for page, start := 1, 0; start < NumberOfClusters; page, start = page+1, start+100 {
+				end := start + 100
+				if end > NumberOfClusters {
+					end = NumberOfClusters
+				}
+				placementDecision := &clusterv1alpha1.PlacementDecision{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      fmt.Sprintf("%s-decision-%d", PlacementStrategyName, page),
+						Namespace: AuthRealmNameSpace,
+						Labels: map[string]string{
+							PlacementLabel: placement.Name,
+						},
+					},
+				}
+				placementDecision, err := clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
+					Create(context.TODO(), placementDecision, metav1.CreateOptions{})
+				Expect(err).To(BeNil())
+
+				for _, clusterName := range ClusterNames[start:end] {
+					placementDecision.Status.Decisions = append(placementDecision.Status.Decisions,
+						clusterv1alpha1.ClusterDecision{ClusterName: clusterName})
+				}
+				_, err = clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
+					UpdateStatus(context.TODO(), placementDecision, metav1.UpdateOptions{})
+				Expect(err).To(BeNil())
+			}
+		})
+		By("Checking the decided clusters", func() {
+			clusters, err := GetPlacementDecisionClusters(context.TODO(), k8sClient, placement)
+			Expect(err).To(BeNil())
+			Expect(clusters).To(Equal(ClusterNames))
+		})
+		r := &PlacementDecisionReconciler{
+			Client: k8sClient,
+			Log:    logf.Log,
+			Scheme: scheme.Scheme,
+		}
+		for i := 1; i <= 2; i++ {
+			By(fmt.Sprintf("Calling reconcile on the placement, run %d", i), func() {
+				req := ctrl.Request{}
+				req.Name = PlacementStrategyName
+				req.Namespace = AuthRealmNameSpace
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).To(BeNil())
+			})
+			By("Checking a DexClient exists for each decided cluster", func() {
+				dexClients := &dexv1alpha1.DexClientList{}
+				err := k8sClient.List(context.TODO(), dexClients, client.InNamespace(AuthRealmName))
+				Expect(err).To(BeNil())
+				Expect(len(dexClients.Items)).To(Equal(NumberOfClusters))
+			})
+		}
+	})
+})
+
+var _ = Describe("Process Strategy backplane for AuthRealms with the same name: ", func() {
+	AuthRealmName := "my-authrealm-shared"
+	AuthRealmNameSpaces := []string{"my-authrealmns-tenant-1", "my-authrealmns-tenant-2"}
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+	PlacementDecisionName := PlacementStrategyName + "-decision-1"
+	ClusterName := "my-cluster-shared"
+	MyIDPName := "my-idp"
+
+	createAuthRealm := func(authRealmNameSpace string) *identitatemv1alpha1.AuthRealm {
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: authRealmNameSpace,
+			},
+		}
+		err := k8sClient.Create(context.TODO(), ns)
+		Expect(err).To(BeNil())
+		placement := &clusterv1alpha1.Placement{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      PlacementStrategyName,
+				Namespace: authRealmNameSpace,
+				Labels: map[string]string{
+					helpers.StrategyLabel: StrategyName,
+				},
+			},
+		}
+		placement, err = clientSetCluster.ClusterV1alpha1().Placements(authRealmNameSpace).
+			Create(context.TODO(), placement, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		authRealm := &identitatemv1alpha1.AuthRealm{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      AuthRealmName,
+				Namespace: authRealmNameSpace,
+			},
+			Spec: identitatemv1alpha1.AuthRealmSpec{
+				Type: identitatemv1alpha1.AuthProxyDex,
+				IdentityProviders: []openshiftconfigv1.IdentityProvider{
+					{
+						Name:          MyIDPName,
+						MappingMethod: openshiftconfigv1.MappingMethodClaim,
+						IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+							Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+							GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+								ClientID: "me",
+							},
+						},
+					},
+				},
+				PlacementRef: corev1.LocalObjectReference{
+					Name: placement.Name,
+				},
+			},
+		}
+		authRealm, err = clientSetMgmt.IdentityconfigV1alpha1().AuthRealms(authRealmNameSpace).Create(context.TODO(), authRealm, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		strategy := &identitatemv1alpha1.Strategy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      StrategyName,
+				Namespace: authRealmNameSpace,
+			},
+			Spec: identitatemv1alpha1.StrategySpec{
+				Type: identitatemv1alpha1.BackplaneStrategyType,
+			},
+		}
+		controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+		_, err = clientSetStrategy.IdentityconfigV1alpha1().Strategies(authRealmNameSpace).Create(context.TODO(), strategy, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		placementDecision := &clusterv1alpha1.PlacementDecision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      PlacementDecisionName,
+				Namespace: authRealmNameSpace,
+				Labels: map[string]string{
+					PlacementLabel: placement.Name,
+				},
+			},
+		}
+		placementDecision, err = clientSetCluster.ClusterV1alpha1().PlacementDecisions(authRealmNameSpace).
+			Create(context.TODO(), placementDecision, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		placementDecision.Status.Decisions = []clusterv1alpha1.ClusterDecision{
+			{
+				ClusterName: ClusterName,
+			},
+		}
+		_, err = clientSetCluster.ClusterV1alpha1().PlacementDecisions(authRealmNameSpace).
+			UpdateStatus(context.TODO(), placementDecision, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+		return authRealm
 	}
-	return crd, nil
-}
+
+	It("isolates the client secrets and DexClients of each AuthRealm", func() {
+		By(fmt.Sprintf("creation of Dex namespace %s and cluster namespace %s", AuthRealmName, ClusterName), func() {
+			for _, name := range []string{AuthRealmName, ClusterName} {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+			for _, authRealmNameSpace := range AuthRealmNameSpaces {
+				createReadyDexServer(authRealmNameSpace, AuthRealmName)
+			}
+		})
+		authRealms := make([]*identitatemv1alpha1.AuthRealm, 0)
+		By("creating an AuthRealm with the same name in each tenant namespace", func() {
+			for _, authRealmNameSpace := range AuthRealmNameSpaces {
+				authRealms = append(authRealms, createAuthRealm(authRealmNameSpace))
+			}
+		})
+		r := &PlacementDecisionReconciler{
+			Client: k8sClient,
+			Log:    logf.Log,
+			Scheme: scheme.Scheme,
+		}
+		By("Calling reconcile for each tenant", func() {
+			for _, authRealmNameSpace := range AuthRealmNameSpaces {
+				req := ctrl.Request{}
+				req.Name = PlacementStrategyName
+				req.Namespace = authRealmNameSpace
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).To(BeNil())
+			}
+		})
+		By("Checking each tenant has its own client secret and DexClient", func() {
+			clientSecrets := make([]*corev1.Secret, 0)
+			for _, authRealm := range authRealms {
+				clientSecret := &corev1.Secret{}
+				err := k8sClient.Get(context.TODO(),
+					client.ObjectKey{Name: helpers.ClientSecretName(authRealm, MyIDPName), Namespace: ClusterName}, clientSecret)
+				Expect(err).To(BeNil())
+				Expect(helpers.IsOwnedByAuthRealm(clientSecret, authRealm)).To(BeTrue())
+				Expect(clientSecret.GetLabels()[helpers.ManagedByLabel]).To(Equal(helpers.ManagedByValue))
+				Expect(clientSecret.GetLabels()[helpers.GeneratedByLabel]).To(Equal(helpers.ManagedByValue))
+				Expect(clientSecret.GetLabels()[helpers.StrategyLabel]).ToNot(BeEmpty())
+				Expect(clientSecret.GetAnnotations()).To(HaveKey(helpers.AuthRealmGenerationAnnotation))
+				clientSecrets = append(clientSecrets, clientSecret)
+
+				dexClient := &dexv1alpha1.DexClient{}
+				err = k8sClient.Get(context.TODO(),
+					client.ObjectKey{Name: helpers.DexClientName(authRealm, ClusterName, MyIDPName), Namespace: AuthRealmName}, dexClient)
+				Expect(err).To(BeNil())
+				Expect(dexClient.Spec.ClientSecret).To(Equal(string(clientSecret.Data["client-secret"])))
+				Expect(dexClient.GetLabels()[helpers.PlacementNameLabel]).ToNot(BeEmpty())
+			}
+			Expect(clientSecrets[0].Name).ToNot(Equal(clientSecrets[1].Name))
+			Expect(clientSecrets[0].Data["client-id"]).ToNot(Equal(clientSecrets[1].Data["client-id"]))
+		})
+		By("Checking a secret not owned by the AuthRealm is reported as a collision", func() {
+			err := k8sClient.Delete(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      helpers.ClientSecretName(authRealms[0], MyIDPName),
+					Namespace: ClusterName,
+				},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      helpers.ClientSecretName(authRealms[0], MyIDPName),
+					Namespace: ClusterName,
+				},
+			})
+			Expect(err).To(BeNil())
+			req := ctrl.Request{}
+			req.Name = PlacementStrategyName
+			req.Namespace = AuthRealmNameSpaces[0]
+			_, err = r.Reconcile(context.TODO(), req)
+			Expect(err).ToNot(BeNil())
+			collisionErr := &helpers.CollisionError{}
+			Expect(errors.As(err, &collisionErr)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Process Strategy backplane in dry-run: ", func() {
+	AuthRealmName := "my-authrealm-dry-run"
+	AuthRealmNameSpace := "my-authrealmns-dry-run"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+	PlacementDecisionName := PlacementStrategyName + "-decision-1"
+	ClusterName := "my-cluster-dry-run"
+	MyIDPName := "my-idp"
+
+	It("renders the resources without writing them", func() {
+		By(fmt.Sprintf("creation of User namespace %s and cluster namespace %s", AuthRealmNameSpace, ClusterName), func() {
+			for _, name := range []string{AuthRealmNameSpace, ClusterName} {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+		})
+		var placement *clusterv1alpha1.Placement
+		By("Creating the placement strategy", func() {
+			placement = &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
+				},
+			}
+			var err error
+			placement, err = clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Create(context.TODO(), placement, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		var authRealm *identitatemv1alpha1.AuthRealm
+		By("creating a AuthRealm CR", func() {
+			var err error
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+					IdentityProviders: []openshiftconfigv1.IdentityProvider{
+						{
+							Name:          MyIDPName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+								GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+									ClientID: "me",
+								},
+							},
+						},
+					},
+					PlacementRef: corev1.LocalObjectReference{
+						Name: placement.Name,
+					},
+				},
+			}
+			authRealm, err = clientSetMgmt.IdentityconfigV1alpha1().AuthRealms(AuthRealmNameSpace).Create(context.TODO(), authRealm, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		By("creating a Strategy CR in dry-run", func() {
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StrategyName,
+					Namespace: AuthRealmNameSpace,
+					Annotations: map[string]string{
+						helpers.DryRunAnnotation: "true",
+					},
+				},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			_, err := clientSetStrategy.IdentityconfigV1alpha1().Strategies(AuthRealmNameSpace).Create(context.TODO(), strategy, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+		})
+		By("Create Placement Decision CR", func() {
+			placementDecision := &clusterv1alpha1.PlacementDecision{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementDecisionName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						PlacementLabel: placement.Name,
+					},
+				},
+			}
+			placementDecision, err := clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
+				Create(context.TODO(), placementDecision, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+			placementDecision.Status.Decisions = []clusterv1alpha1.ClusterDecision{
+				{
+					ClusterName: ClusterName,
+				},
+			}
+			_, err = clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
+				UpdateStatus(context.TODO(), placementDecision, metav1.UpdateOptions{})
+			Expect(err).To(BeNil())
+		})
+		By("Calling reconcile", func() {
+			r := &PlacementDecisionReconciler{
+				Client: k8sClient,
+				Log:    logf.Log,
+				Scheme: scheme.Scheme,
+			}
+			req := ctrl.Request{}
+			req.Name = PlacementStrategyName
+			req.Namespace = AuthRealmNameSpace
+			_, err := r.Reconcile(context.TODO(), req)
+			Expect(err).To(BeNil())
+		})
+		By("Checking the dry-run ConfigMaps", func() {
+			dryRunStrategy := &identitatemv1alpha1.Strategy{ObjectMeta: metav1.ObjectMeta{Name: StrategyName}}
+			cm := &corev1.ConfigMap{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName + "-dry-run", Namespace: AuthRealmNameSpace}, cm)
+			Expect(err).To(BeNil())
+			Expect(cm.Data["decisions.yaml"]).To(ContainSubstring(ClusterName))
+			Expect(cm.Data["configmaps.yaml"]).To(ContainSubstring(helpers.ClusterDryRunConfigMapName(dryRunStrategy, ClusterName)))
+			//Each cluster has its own ConfigMap
+			err = k8sClient.Get(context.TODO(), client.ObjectKey{
+				Name:      helpers.ClusterDryRunConfigMapName(dryRunStrategy, ClusterName),
+				Namespace: AuthRealmNameSpace,
+			}, cm)
+			Expect(err).To(BeNil())
+			Expect(cm.Labels[helpers.DryRunClusterLabel]).To(Equal(ClusterName))
+			Expect(cm.Data["dexclients.yaml"]).To(ContainSubstring(helpers.DexClientName(authRealm, ClusterName, MyIDPName)))
+			Expect(cm.Data["clusteroauths.yaml"]).To(ContainSubstring(helpers.ClusterOAuthName(authRealm, identitatemv1alpha1.BackplaneStrategyType)))
+			Expect(cm.Data["dexclients.yaml"]).To(ContainSubstring(helpers.RedactedValue))
+			Expect(cm.Data["manifestwork.yaml"]).To(ContainSubstring("idp-backplane"))
+			//The ManifestWork is built from the previewed ClusterOAuths
+			Expect(cm.Data["manifestwork.yaml"]).To(ContainSubstring(helpers.IdentityProviderName(authRealm, MyIDPName)))
+			Expect(cm.Data["manifestwork.yaml"]).To(ContainSubstring(helpers.RedactedValue))
+		})
+		By("Checking nothing has been written", func() {
+			clientSecret := &corev1.Secret{}
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.ClientSecretName(authRealm, MyIDPName), Namespace: ClusterName}, clientSecret)
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			dexClients := &dexv1alpha1.DexClientList{}
+			err = k8sClient.List(context.TODO(), dexClients, client.InNamespace(AuthRealmName))
+			Expect(err).To(BeNil())
+			Expect(len(dexClients.Items)).To(Equal(0))
+		})
+		By("Deleting the dry-run ConfigMaps", func() {
+			strategy := &identitatemv1alpha1.Strategy{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+			Expect(err).To(BeNil())
+			//A ConfigMap not owned by the Strategy is kept
+			notOwned := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      helpers.ClusterDryRunConfigMapName(strategy, "my-other-cluster"),
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.DryRunLabel:        StrategyName,
+						helpers.DryRunClusterLabel: "my-other-cluster",
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), notOwned)
+			Expect(err).To(BeNil())
+			err = helpers.DeleteDryRunConfigMap(context.TODO(), k8sClient, strategy)
+			Expect(err).To(BeNil())
+			for _, name := range []string{
+				helpers.DryRunConfigMapName(strategy),
+				helpers.ClusterDryRunConfigMapName(strategy, ClusterName),
+			} {
+				err = k8sClient.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: AuthRealmNameSpace}, &corev1.ConfigMap{})
+				Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			}
+			err = k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(notOwned), &corev1.ConfigMap{})
+			Expect(err).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Read the rollout state of the clusters: ", func() {
+	ClusterName := "my-cluster-rollout-states"
+	AuthRealmNameSpace := "my-authrealmns-rollout-states"
+
+	r := &PlacementDecisionReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	strategy := &identitatemv1alpha1.Strategy{
+		Spec: identitatemv1alpha1.StrategySpec{
+			Type: identitatemv1alpha1.BackplaneStrategyType,
+		},
+	}
+	authRealm1 := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-authrealm-1", Namespace: AuthRealmNameSpace},
+	}
+	authRealm2 := &identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-authrealm-2", Namespace: AuthRealmNameSpace},
+	}
+	createClusterOAuth := func(authRealm *identitatemv1alpha1.AuthRealm, annotations map[string]string) {
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        helpers.ClusterOAuthName(authRealm, strategy.Spec.Type),
+				Namespace:   ClusterName,
+				Labels:      helpers.AuthRealmLabels(authRealm),
+				Annotations: annotations,
+			},
+		}
+		err := k8sClient.Create(context.TODO(), clusterOAuth)
+		Expect(err).To(BeNil())
+	}
+
+	It("reads the revision of each AuthRealm from its ClusterOAuth", func() {
+		By(fmt.Sprintf("creation of cluster namespace %s", ClusterName), func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: ClusterName,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+		})
+		By("creation of the ManifestWork available at the manifests revision mw-2", func() {
+			mw := &workv1.ManifestWork{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      BackplaneManifestWorkName,
+					Namespace: ClusterName,
+					Annotations: map[string]string{
+						clusteroauth.ManifestsRevisionAnnotation: "mw-2",
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), mw)
+			Expect(err).To(BeNil())
+			meta.SetStatusCondition(&mw.Status.Conditions, metav1.Condition{
+				Type:               workv1.WorkAvailable,
+				Status:             metav1.ConditionTrue,
+				Reason:             "Available",
+				ObservedGeneration: mw.Generation,
+			})
+			err = k8sClient.Status().Update(context.TODO(), mw)
+			Expect(err).To(BeNil())
+		})
+		By("creation of the ClusterOAuths of 2 AuthRealms, only the revision of the first is delivered", func() {
+			createClusterOAuth(authRealm1, map[string]string{
+				RolloutRevisionAnnotation:                         "revision-1",
+				clusteroauth.DeliveredRolloutRevisionAnnotation:   "revision-1",
+				clusteroauth.DeliveredManifestsRevisionAnnotation: "mw-2",
+			})
+			createClusterOAuth(authRealm2, map[string]string{
+				RolloutRevisionAnnotation:                         "revision-2",
+				clusteroauth.DeliveredRolloutRevisionAnnotation:   "revision-1",
+				clusteroauth.DeliveredManifestsRevisionAnnotation: "mw-1",
+			})
+		})
+		By("Checking the state of each AuthRealm", func() {
+			states, err := r.getClusterRolloutStates(context.TODO(), strategy, authRealm1, []string{ClusterName})
+			Expect(err).To(BeNil())
+			Expect(states[ClusterName].revision).To(Equal("revision-1"))
+			Expect(states[ClusterName].available).To(BeTrue())
+
+			states, err = r.getClusterRolloutStates(context.TODO(), strategy, authRealm2, []string{ClusterName})
+			Expect(err).To(BeNil())
+			Expect(states[ClusterName].revision).To(Equal("revision-2"))
+			Expect(states[ClusterName].available).To(BeFalse())
+			Expect(states[ClusterName].failed).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Plan the rollout of a Strategy: ", func() {
+	Revision := "rev-2"
+	Now := time.Now()
+	Clusters := []string{"cluster-1", "cluster-2", "cluster-3", "cluster-4", "cluster-5"}
+	Policy := &rolloutPolicy{
+		batchSize:   intstr.FromInt(2),
+		pause:       time.Minute,
+		maxFailures: 1,
+	}
+
+	It("updates all clusters without rollout policy", func() {
+		plan := planRollout(nil, Clusters, nil, Revision, Now)
+		Expect(plan.clusters).To(Equal(Clusters))
+		Expect(plan.completed).To(BeTrue())
+	})
+	It("starts with the first batch", func() {
+		states := map[string]clusterRolloutState{
+			"cluster-1": {revision: "rev-1", available: true},
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal([]string{"cluster-1", "cluster-2"}))
+	})
+	It("waits for the batch to be available", func() {
+		states := map[string]clusterRolloutState{
+			"cluster-1": {revision: Revision, available: true, timestamp: Now.Add(-time.Hour)},
+			"cluster-2": {revision: Revision, available: false, timestamp: Now.Add(-time.Hour)},
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal([]string{"cluster-1", "cluster-2"}))
+		Expect(plan.requeueAfter).ToNot(BeZero())
+	})
+	It("pauses between batches", func() {
+		states := map[string]clusterRolloutState{
+			"cluster-1": {revision: Revision, available: true, timestamp: Now.Add(-time.Second)},
+			"cluster-2": {revision: Revision, available: true, timestamp: Now.Add(-time.Second)},
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal([]string{"cluster-1", "cluster-2"}))
+		Expect(plan.paused).To(BeTrue())
+	})
+	It("continues with the next batch", func() {
+		states := map[string]clusterRolloutState{
+			"cluster-1": {revision: Revision, available: true, timestamp: Now.Add(-time.Hour)},
+			"cluster-2": {revision: Revision, available: true, timestamp: Now.Add(-time.Hour)},
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal([]string{"cluster-1", "cluster-2", "cluster-3", "cluster-4"}))
+	})
+	It("halts on failure", func() {
+		states := map[string]clusterRolloutState{
+			"cluster-1": {revision: Revision, available: true, timestamp: Now.Add(-time.Hour)},
+			"cluster-2": {revision: Revision, failed: true, timestamp: Now.Add(-time.Hour)},
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal([]string{"cluster-1", "cluster-2"}))
+		Expect(plan.halted).To(BeTrue())
+		Expect(plan.failed).To(Equal([]string{"cluster-2"}))
+	})
+	It("completes when all clusters are available", func() {
+		states := make(map[string]clusterRolloutState)
+		for _, clusterName := range Clusters {
+			states[clusterName] = clusterRolloutState{revision: Revision, available: true}
+		}
+		plan := planRollout(Policy, Clusters, states, Revision, Now)
+		Expect(plan.clusters).To(Equal(Clusters))
+		Expect(plan.completed).To(BeTrue())
+	})
+	It("reads the policy from the Strategy annotations", func() {
+		strategy := &identitatemv1alpha1.Strategy{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					RolloutBatchSizeAnnotation:   "20%",
+					RolloutPauseAnnotation:       "5m",
+					RolloutMaxFailuresAnnotation: "3",
+				},
+			},
+		}
+		policy, err := getRolloutPolicy(strategy)
+		Expect(err).To(BeNil())
+		Expect(policy.batchSize).To(Equal(intstr.FromString("20%")))
+		Expect(policy.pause).To(Equal(5 * time.Minute))
+		Expect(policy.maxFailures).To(Equal(3))
+	})
+})
+
+// createReadyDexServer creates in the Dex namespace the DexServer of an AuthRealm with its available Deployment and admitted Route
+func createReadyDexServer(authRealmNameSpace, authRealmName string) *dexv1alpha1.DexServer {
+	dexNamespace := authRealmName
+	name := helpers.DexServerName(&identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: authRealmName, Namespace: authRealmNameSpace},
+	})
+	dexServer := &dexv1alpha1.DexServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dexNamespace,
+		},
+		Spec: dexv1alpha1.DexServerSpec{
+			Issuer: fmt.Sprintf("https://%s.apps.example.com", name),
+		},
+	}
+	err := k8sClient.Create(context.TODO(), dexServer)
+	Expect(err).To(BeNil())
+
+	labels := map[string]string{"app": name}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dexNamespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "dex", Image: "dex"}},
+				},
+			},
+		},
+	}
+	err = controllerutil.SetOwnerReference(dexServer, deployment, scheme.Scheme)
+	Expect(err).To(BeNil())
+	err = k8sClient.Create(context.TODO(), deployment)
+	Expect(err).To(BeNil())
+	deployment.Status.Replicas = 1
+	deployment.Status.AvailableReplicas = 1
+	err = k8sClient.Status().Update(context.TODO(), deployment)
+	Expect(err).To(BeNil())
+
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dexNamespace,
+		},
+		Spec: routev1.RouteSpec{
+			Host: fmt.Sprintf("%s.apps.example.com", name),
+		},
+	}
+	err = k8sClient.Create(context.TODO(), route)
+	Expect(err).To(BeNil())
+	route.Status.Ingress = []routev1.RouteIngress{
+		{
+			Host: route.Spec.Host,
+			Conditions: []routev1.RouteIngressCondition{
+				{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	err = k8sClient.Status().Update(context.TODO(), route)
+	Expect(err).To(BeNil())
+	return dexServer
+}
+
+var _ = Describe("Wait for the DexServer: ", func() {
+	AuthRealmName := "my-authrealm-waiting"
+	AuthRealmNameSpace := "my-authrealmns-waiting"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+
+	It("backs off while the DexServer is not ready", func() {
+		By(fmt.Sprintf("creation of User namespace %s and Dex namespace %s", AuthRealmNameSpace, AuthRealmName), func() {
+			for _, name := range []string{AuthRealmNameSpace, AuthRealmName} {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+		})
+		var authRealm *identitatemv1alpha1.AuthRealm
+		By("creation of the AuthRealm, its Strategy and the Placement of the Strategy", func() {
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StrategyName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			err = controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			placement := &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
+				},
+			}
+			err = controllerutil.SetOwnerReference(strategy, placement, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), placement)
+			Expect(err).To(BeNil())
+		})
+		r := &PlacementDecisionReconciler{
+			Client: k8sClient,
+			Log:    logf.Log,
+			Scheme: scheme.Scheme,
+		}
+		req := ctrl.Request{}
+		req.Name = PlacementStrategyName
+		req.Namespace = AuthRealmNameSpace
+		getWaitingCondition := func() *metav1.Condition {
+			strategy := &identitatemv1alpha1.Strategy{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+			Expect(err).To(BeNil())
+			return meta.FindStatusCondition(strategy.Status.Conditions, WaitingCondition)
+		}
+		By("Checking the missing DexServer is reported without error", func() {
+			result, err := r.Reconcile(context.TODO(), req)
+			Expect(err).To(BeNil())
+			Expect(result.RequeueAfter).To(Equal(dexServerMinBackoff))
+			condition := getWaitingCondition()
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Message).To(ContainSubstring("not found"))
+		})
+		By("Checking the backoff grows with the waiting time", func() {
+			strategy := &identitatemv1alpha1.Strategy{}
+			err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+			Expect(err).To(BeNil())
+			waitingSince := getWaitingCondition().LastTransitionTime.Time
+			Expect(dexServerBackoff(strategy, waitingSince.Add(time.Minute))).To(Equal(time.Minute))
+			Expect(dexServerBackoff(strategy, waitingSince.Add(time.Hour))).To(Equal(dexServerMaxBackoff))
+		})
+		By("Checking the Waiting condition is cleared once the DexServer is ready", func() {
+			createReadyDexServer(AuthRealmNameSpace, AuthRealmName)
+			_, err := r.Reconcile(context.TODO(), req)
+			Expect(err).To(BeNil())
+			condition := getWaitingCondition()
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		})
+		By("Checking an AuthRealm with the same name in another namespace does not get the Dex namespace", func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: AuthRealmNameSpace + "-2",
+				},
+			}
+			err := k8sClient.Create(context.TODO(), ns)
+			Expect(err).To(BeNil())
+			other := &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: ns.Name,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+				},
+			}
+			err = k8sClient.Create(context.TODO(), other)
+			Expect(err).To(BeNil())
+			conflict, err := helpers.GetDexNamespaceConflict(context.TODO(), k8sClient, other)
+			Expect(err).To(BeNil())
+			Expect(conflict).To(ContainSubstring(AuthRealmNameSpace + "/" + AuthRealmName))
+			conflict, err = helpers.GetDexNamespaceConflict(context.TODO(), k8sClient, authRealm)
+			Expect(err).To(BeNil())
+			Expect(conflict).To(BeEmpty())
+			_, err = r.Reconcile(context.TODO(), req)
+			Expect(err).To(BeNil())
+			Expect(getWaitingCondition().Status).To(Equal(metav1.ConditionFalse))
+			err = k8sClient.Delete(context.TODO(), other)
+			Expect(err).To(BeNil())
+		})
+	})
+})
+
+func getCRD(reader *clusteradmasset.ScenarioResourcesReader, file string) (*apiextensionsv1.CustomResourceDefinition, error) {
+	b, err := reader.Asset(file)
+	if err != nil {
+		return nil, err
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(b, crd); err != nil {
+		return nil, err
+	}
+	return crd, nil
+}
+
+var _ = Describe("Render the redirect URIs and rotate the client secrets: ", func() {
+	Data := &RedirectTemplateData{
+		Scheme:   "https",
+		AppsHost: "apps.hub.example.com",
+	}
+
+	It("renders the default redirect URI", func() {
+		r := &PlacementDecisionReconciler{}
+		redirectURI, err := r.getRedirectURI(Data, "cluster-1", "my-idp")
+		Expect(err).To(BeNil())
+		Expect(redirectURI).To(Equal("https://apps.hub.example.com/oauth2callback/idpserver"))
+	})
+	It("renders the configured redirect template", func() {
+		t, err := ParseRedirectTemplate("{{.Scheme}}://oauth-openshift.apps.{{.ClusterName}}.example.com/oauth2callback/{{.IdentityProvider}}")
+		Expect(err).To(BeNil())
+		r := &PlacementDecisionReconciler{RedirectTemplate: t}
+		redirectURI, err := r.getRedirectURI(Data, "cluster-1", "my-idp")
+		Expect(err).To(BeNil())
+		Expect(redirectURI).To(Equal("https://oauth-openshift.apps.cluster-1.example.com/oauth2callback/my-idp"))
+	})
+	It("rejects an invalid redirect template", func() {
+		_, err := ParseRedirectTemplate("{{.Scheme}}://{{.Unknown}}")
+		Expect(err).ToNot(BeNil())
+	})
+	It("never rotates the client secrets without rotation period", func() {
+		r := &PlacementDecisionReconciler{}
+		clientSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-1000 * time.Hour)),
+			},
+		}
+		Expect(r.clientSecretRotation(clientSecret, time.Now())).To(BeZero())
+	})
+	It("rotates the client secrets older than the rotation period", func() {
+		r := &PlacementDecisionReconciler{RotationPeriod: time.Hour}
+		now := time.Now()
+		clientSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			},
+		}
+		Expect(r.clientSecretRotation(clientSecret, now) < 0).To(BeTrue())
+
+		clientSecret.Annotations = map[string]string{
+			ClientSecretRotatedAtAnnotation: now.Add(-30 * time.Minute).UTC().Format(time.RFC3339),
+		}
+		Expect(r.clientSecretRotation(clientSecret, now)).To(BeNumerically("~", 30*time.Minute, time.Second))
+	})
+})
+
+var _ = Describe("Map the claims of the OpenID IdentityProviders: ", func() {
+	It("uses the standard claims by default", func() {
+		authRealm := &identitatemv1alpha1.AuthRealm{}
+		claims := getOpenIDClaims(authRealm)
+		Expect(claims.PreferredUsername).To(Equal([]string{"preferred_username"}))
+		Expect(claims.Name).To(Equal([]string{"name"}))
+		Expect(claims.Email).To(Equal([]string{"email"}))
+		Expect(getClaimsAnnotations(authRealm)).To(BeEmpty())
+	})
+	It("reads the claims from the AuthRealm annotations", func() {
+		authRealm := &identitatemv1alpha1.AuthRealm{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					PreferredUsernameClaimsAnnotation: "login, preferred_username",
+					EmailClaimsAnnotation:             "",
+				},
+			},
+		}
+		claims := getOpenIDClaims(authRealm)
+		Expect(claims.PreferredUsername).To(Equal([]string{"login", "preferred_username"}))
+		Expect(claims.Name).To(Equal([]string{"name"}))
+		Expect(claims.Email).To(BeEmpty())
+	})
+	It("changes the rollout revision only when the claims are configured", func() {
+		authRealm := &identitatemv1alpha1.AuthRealm{}
+		revision, err := getRolloutRevision(authRealm, nil)
+		Expect(err).To(BeNil())
+		authRealm.Annotations = map[string]string{NameClaimsAnnotation: "nickname"}
+		claimsRevision, err := getRolloutRevision(authRealm, nil)
+		Expect(err).To(BeNil())
+		Expect(claimsRevision).ToNot(Equal(revision))
+	})
+	It("changes the rollout revision with the groups", func() {
+		authRealm := &identitatemv1alpha1.AuthRealm{}
+		revision, err := getRolloutRevision(authRealm, nil)
+		Expect(err).To(BeNil())
+		groupsRevision, err := getRolloutRevision(authRealm, []helpers.Group{{Name: "admins", Users: []string{"alice"}}})
+		Expect(err).To(BeNil())
+		Expect(groupsRevision).ToNot(Equal(revision))
+		membersRevision, err := getRolloutRevision(authRealm, []helpers.Group{{Name: "admins", Users: []string{"bob"}}})
+		Expect(err).To(BeNil())
+		Expect(membersRevision).ToNot(Equal(groupsRevision))
+	})
+})
+
+var _ = Describe("Synchronize the groups: ", func() {
+	AuthRealmName := "my-authrealm-groups"
+	AuthRealmNameSpace := "my-authrealmns-groups"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+	SourceName := "my-groups"
+	ClusterNames := []string{"my-cluster-groups-1", "my-cluster-groups-2"}
+
+	r := &PlacementDecisionReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	getStrategy := func() *identitatemv1alpha1.Strategy {
+		strategy := &identitatemv1alpha1.Strategy{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+		Expect(err).To(BeNil())
+		return strategy
+	}
+
+	It("copies the groups of the source in the cluster namespaces and prunes them", func() {
+		By("creation of the namespaces", func() {
+			for _, name := range append([]string{AuthRealmNameSpace}, ClusterNames...) {
+				ns := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: name,
+					},
+				}
+				err := k8sClient.Create(context.TODO(), ns)
+				Expect(err).To(BeNil())
+			}
+		})
+		var authRealm *identitatemv1alpha1.AuthRealm
+		var placement *clusterv1alpha1.Placement
+		By("creation of the AuthRealm, its Strategy with group sync and the Placement of the Strategy", func() {
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+				},
+			}
+			err := k8sClient.Create(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StrategyName,
+					Namespace: AuthRealmNameSpace,
+					Annotations: map[string]string{
+						GroupSyncSourceAnnotation: SourceName,
+					},
+				},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			err = controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			placement = &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), placement)
+			Expect(err).To(BeNil())
+		})
+		syncGroups := func(clusters, updatedClusters []string) {
+			strategy := getStrategy()
+			sync, err := r.getGroupSync(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			err = r.syncGroups(context.TODO(), strategy, authRealm, placement, clusters, updatedClusters, sync)
+			Expect(err).To(BeNil())
+		}
+		getGroups := func(clusterName string) []helpers.Group {
+			cm := &corev1.ConfigMap{}
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.GroupsConfigMapName(authRealm), Namespace: clusterName}, cm)
+			Expect(err).To(BeNil())
+			Expect(cm.GetLabels()).To(HaveKey(helpers.GroupSyncLabel))
+			Expect(helpers.IsOwnedByAuthRealm(cm, authRealm)).To(BeTrue())
+			groups, err := helpers.ParseGroups(cm)
+			Expect(err).To(BeNil())
+			return groups
+		}
+		By("Checking the missing source is reported", func() {
+			syncGroups(ClusterNames, ClusterNames)
+			condition := meta.FindStatusCondition(getStrategy().Status.Conditions, GroupSyncReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("SourceNotFound"))
+		})
+		source := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      SourceName,
+				Namespace: AuthRealmNameSpace,
+			},
+			Data: map[string]string{
+				helpers.GroupsKey: "- name: \"team a:admins\"\n  users: [alice, bob]\n",
+			},
+		}
+		By("creation of the source of the groups", func() {
+			Expect(r.groupSyncSourceRequest(source)).To(ConsistOf(reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: PlacementStrategyName, Namespace: AuthRealmNameSpace},
+			}))
+			err := k8sClient.Create(context.TODO(), source)
+			Expect(err).To(BeNil())
+		})
+		By("Checking the groups are only copied in the namespace of the clusters updated by the rollout", func() {
+			syncGroups(ClusterNames, ClusterNames[:1])
+			Expect(getGroups(ClusterNames[0])).To(Equal([]helpers.Group{{Name: "team a:admins", Users: []string{"alice", "bob"}}}))
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.GroupsConfigMapName(authRealm), Namespace: ClusterNames[1]}, &corev1.ConfigMap{})
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+		})
+		By("Checking the groups are copied in the namespace of all decided clusters once updated", func() {
+			syncGroups(ClusterNames, ClusterNames)
+			for _, clusterName := range ClusterNames {
+				Expect(getGroups(clusterName)).To(Equal([]helpers.Group{{Name: "team a:admins", Users: []string{"alice", "bob"}}}))
+			}
+			condition := meta.FindStatusCondition(getStrategy().Status.Conditions, GroupSyncReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		})
+		By("Checking a copy maps to its Placement", func() {
+			cm := &corev1.ConfigMap{}
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.GroupsConfigMapName(authRealm), Namespace: ClusterNames[1]}, cm)
+			Expect(err).To(BeNil())
+			Expect(r.groupSyncSourceRequest(cm)).To(ConsistOf(reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: PlacementStrategyName, Namespace: AuthRealmNameSpace},
+			}))
+		})
+		By("Checking an invalid source is reported and the copies are kept", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(source), source)
+			Expect(err).To(BeNil())
+			source.Data[helpers.GroupsKey] = "- name: admins\n- name: admins\n"
+			err = k8sClient.Update(context.TODO(), source)
+			Expect(err).To(BeNil())
+			syncGroups(ClusterNames, ClusterNames)
+			condition := meta.FindStatusCondition(getStrategy().Status.Conditions, GroupSyncReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("InvalidSource"))
+			Expect(getGroups(ClusterNames[1])).To(Equal([]helpers.Group{{Name: "team a:admins", Users: []string{"alice", "bob"}}}))
+		})
+		By("Checking the groups of the clusters no longer decided are deleted", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(source), source)
+			Expect(err).To(BeNil())
+			source.Data[helpers.GroupsKey] = "- name: admins\n"
+			err = k8sClient.Update(context.TODO(), source)
+			Expect(err).To(BeNil())
+			syncGroups(ClusterNames[:1], ClusterNames[:1])
+			err = k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.GroupsConfigMapName(authRealm), Namespace: ClusterNames[1]}, &corev1.ConfigMap{})
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+		})
+		By("Checking the groups are deleted once the group sync is disabled", func() {
+			strategy := getStrategy()
+			delete(strategy.Annotations, GroupSyncSourceAnnotation)
+			err := k8sClient.Update(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			syncGroups(ClusterNames[:1], ClusterNames[:1])
+			err = k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.GroupsConfigMapName(authRealm), Namespace: ClusterNames[0]}, &corev1.ConfigMap{})
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			Expect(meta.FindStatusCondition(getStrategy().Status.Conditions, GroupSyncReadyCondition)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Reconcile the changes through the manager: ", func() {
+	AuthRealmName := "my-authrealm-manager"
+	AuthRealmNameSpace := "my-authrealmns-manager"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementStrategyName := StrategyName
+	ClusterName := "my-cluster-manager"
+	MyIDPName := "my-idp"
+
+	var authRealm *identitatemv1alpha1.AuthRealm
+	var strategy *identitatemv1alpha1.Strategy
+	getClusterOAuth := func() (*identitatemv1alpha1.ClusterOAuth, error) {
+		clusterOAuth := &identitatemv1alpha1.ClusterOAuth{}
+		err := k8sClient.Get(context.TODO(),
+			client.ObjectKey{Name: helpers.ClusterOAuthName(authRealm, identitatemv1alpha1.BackplaneStrategyType), Namespace: ClusterName},
+			clusterOAuth)
+		return clusterOAuth, err
+	}
+
+	It("reconciles the Placement of the Strategy when the AuthRealm, Strategy or DexServer change", func() {
+		By("creation of the namespaces and the DexServer", func() {
+			for _, name := range []string{AuthRealmNameSpace, AuthRealmName, ClusterName} {
+				err := k8sClient.Create(context.TODO(), &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+				})
+				Expect(err).To(BeNil())
+			}
+			createReadyDexServer(AuthRealmNameSpace, AuthRealmName)
+		})
+		By("creation of the AuthRealm, its Strategy, the Placement of the Strategy and its decision", func() {
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AuthRealmName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+					IdentityProviders: []openshiftconfigv1.IdentityProvider{
+						{
+							Name:          MyIDPName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+								GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+									ClientID: "me",
+								},
+							},
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			strategy = &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StrategyName,
+					Namespace: AuthRealmNameSpace,
+				},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			err = controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			placement := &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName,
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						helpers.StrategyLabel: StrategyName,
+					},
+				},
+			}
+			err = controllerutil.SetOwnerReference(strategy, placement, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), placement)
+			Expect(err).To(BeNil())
+			placementDecision := &clusterv1alpha1.PlacementDecision{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PlacementStrategyName + "-decision-1",
+					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						PlacementLabel: PlacementStrategyName,
+					},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), placementDecision)
+			Expect(err).To(BeNil())
+			placementDecision.Status.Decisions = []clusterv1alpha1.ClusterDecision{{ClusterName: ClusterName}}
+			err = k8sClient.Status().Update(context.TODO(), placementDecision)
+			Expect(err).To(BeNil())
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		By("starting the manager with the placementdecision controller", func() {
+			mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+				Scheme:             scheme.Scheme,
+				MetricsBindAddress: "0",
+			})
+			Expect(err).To(BeNil())
+			r := &PlacementDecisionReconciler{
+				Client:    mgr.GetClient(),
+				APIReader: mgr.GetAPIReader(),
+				Log:       logf.Log,
+				Scheme:    mgr.GetScheme(),
+			}
+			err = r.SetupWithManager(mgr)
+			Expect(err).To(BeNil())
+			go func() {
+				defer GinkgoRecover()
+				err := mgr.Start(ctx)
+				Expect(err).To(BeNil())
+			}()
+		})
+		var revision string
+		By("Checking the ClusterOAuth is generated for the decision", func() {
+			Eventually(func() error {
+				clusterOAuth, err := getClusterOAuth()
+				if err != nil {
+					return err
+				}
+				revision = clusterOAuth.GetAnnotations()[RolloutRevisionAnnotation]
+				return nil
+			}, 30*time.Second, time.Second).Should(Succeed())
+			Expect(revision).ToNot(BeEmpty())
+		})
+		By("Checking a change of the AuthRealm rolls out a new revision", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(authRealm), authRealm)
+			Expect(err).To(BeNil())
+			authRealm.Spec.IdentityProviders[0].MappingMethod = openshiftconfigv1.MappingMethodLookup
+			err = k8sClient.Update(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			Eventually(func() string {
+				clusterOAuth, err := getClusterOAuth()
+				if err != nil {
+					return ""
+				}
+				return clusterOAuth.GetAnnotations()[RolloutRevisionAnnotation]
+			}, 30*time.Second, time.Second).ShouldNot(Equal(revision))
+			clusterOAuth, err := getClusterOAuth()
+			Expect(err).To(BeNil())
+			Expect(clusterOAuth.Spec.OAuth.Spec.IdentityProviders[0].MappingMethod).To(Equal(openshiftconfigv1.MappingMethodLookup))
+		})
+		By("Checking a change of the issuer of the DexServer regenerates the ClusterOAuth", func() {
+			dexServer := &dexv1alpha1.DexServer{}
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.DexServerName(authRealm), Namespace: helpers.DexNamespace(authRealm)}, dexServer)
+			Expect(err).To(BeNil())
+			issuer := dexServer.Spec.Issuer + "/dex"
+			dexServer.Spec.Issuer = issuer
+			err = k8sClient.Update(context.TODO(), dexServer)
+			Expect(err).To(BeNil())
+			Eventually(func() string {
+				clusterOAuth, err := getClusterOAuth()
+				if err != nil {
+					return ""
+				}
+				return clusterOAuth.Spec.OAuth.Spec.IdentityProviders[0].OpenID.Issuer
+			}, 30*time.Second, time.Second).Should(Equal(issuer))
+		})
+		By("Checking a change of the claims annotations of the AuthRealm regenerates the ClusterOAuth", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(authRealm), authRealm)
+			Expect(err).To(BeNil())
+			authRealm.Annotations = map[string]string{EmailClaimsAnnotation: "mail"}
+			err = k8sClient.Update(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			Eventually(func() []string {
+				clusterOAuth, err := getClusterOAuth()
+				if err != nil {
+					return nil
+				}
+				return clusterOAuth.Spec.OAuth.Spec.IdentityProviders[0].OpenID.Claims.Email
+			}, 30*time.Second, time.Second).Should(Equal([]string{"mail"}))
+		})
+		By("Checking a change of the Strategy annotations is reconciled", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(strategy), strategy)
+			Expect(err).To(BeNil())
+			strategy.Annotations = map[string]string{RolloutBatchSizeAnnotation: "1"}
+			err = k8sClient.Update(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			Eventually(func() *metav1.Condition {
+				err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(strategy), strategy)
+				if err != nil {
+					return nil
+				}
+				return meta.FindStatusCondition(strategy.Status.Conditions, RolloutProgressingCondition)
+			}, 30*time.Second, time.Second).ShouldNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,241 @@
+// Copyright Red Hat
+
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	openshiftconfigv1 "github.com/openshift/api/config/v1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
+)
+
+const (
+	// DexConnectorLabel is set on the connector secrets of the Dex namespace with the name of their IdentityProvider
+	DexConnectorLabel string = "identityconfig.identitatem.io/dex-connector"
+)
+
+// dexConnector is the Dex connector of an IdentityProvider and the hub secret it references
+type dexConnector struct {
+	connector identitatemdexv1alpha1.ConnectorSpec
+	// secretName is the name of the secret referenced by the IdentityProvider in the AuthRealm namespace,
+	// empty if none
+	secretName string
+}
+
+// newDexConnector translates an IdentityProvider into a Dex connector, it returns why
+// if the IdentityProvider can't be translated.
+// The connector config of the dex-operator only carries client credentials and a GitHub organization:
+// the LDAP host and the OpenID issuer can't be configured so these IdentityProviders are not translated,
+// and only the first GitHub organization is kept.
+func newDexConnector(idp *openshiftconfigv1.IdentityProvider, issuer string) (*dexConnector, string) {
+	c := &dexConnector{
+		connector: identitatemdexv1alpha1.ConnectorSpec{
+			Name: idp.Name,
+			Id:   idp.Name,
+		},
+	}
+	switch {
+	case idp.GitHub != nil:
+		c.connector.Type = "github"
+		c.connector.Config.ClientID = idp.GitHub.ClientID
+		c.secretName = idp.GitHub.ClientSecret.Name
+		if len(idp.GitHub.Organizations) != 0 {
+			c.connector.Config.Org = idp.GitHub.Organizations[0]
+		}
+	case idp.GitLab != nil:
+		c.connector.Type = "gitlab"
+		c.connector.Config.ClientID = idp.GitLab.ClientID
+		c.secretName = idp.GitLab.ClientSecret.Name
+	case idp.Google != nil:
+		c.connector.Type = "google"
+		c.connector.Config.ClientID = idp.Google.ClientID
+		c.secretName = idp.Google.ClientSecret.Name
+	case idp.OpenID != nil:
+		return nil, fmt.Sprintf("the issuer %s can't be configured on the Dex connector", idp.OpenID.Issuer)
+	case idp.LDAP != nil:
+		return nil, fmt.Sprintf("the host of %s can't be configured on the Dex connector", idp.LDAP.URL)
+	default:
+		return nil, fmt.Sprintf("type %s has no Dex connector", idp.Type)
+	}
+	if len(issuer) != 0 {
+		c.connector.Config.RedirectURI = strings.TrimSuffix(issuer, "/") + "/callback"
+	}
+	return c, ""
+}
+
+// getDexServer returns the DexServer of the AuthRealm, nil if not found
+func (r *StrategyReconciler) getDexServer(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm) (*identitatemdexv1alpha1.DexServer, error) {
+	dexServer := &identitatemdexv1alpha1.DexServer{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: helpers.DexServerName(authrealm), Namespace: helpers.DexNamespace(authrealm)}, dexServer)
+	switch {
+	case errors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return dexServer, nil
+}
+
+// buildDexConnectors translates the IdentityProviders of the AuthRealm into Dex connectors
+// and returns the copies of their secrets to deliver in the Dex namespace.
+// The IdentityProviders which can't be translated are left out and reported in the returned problems.
+func (r *StrategyReconciler) buildDexConnectors(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	issuer string) ([]identitatemdexv1alpha1.ConnectorSpec, []*corev1.Secret, []string, error) {
+	connectors := make([]identitatemdexv1alpha1.ConnectorSpec, 0)
+	secrets := make([]*corev1.Secret, 0)
+	problems := make([]string, 0)
+	for i := range authrealm.Spec.IdentityProviders {
+		idp := &authrealm.Spec.IdentityProviders[i]
+		c, problem := newDexConnector(idp, issuer)
+		if len(problem) != 0 {
+			problems = append(problems, fmt.Sprintf("identity provider %s: %s", idp.Name, problem))
+			continue
+		}
+		if len(c.secretName) != 0 {
+			secret := &corev1.Secret{}
+			err := r.Client.Get(ctx, client.ObjectKey{Name: c.secretName, Namespace: authrealm.Namespace}, secret)
+			switch {
+			case errors.IsNotFound(err):
+				problems = append(problems, fmt.Sprintf("identity provider %s: secret %s/%s not found",
+					idp.Name, authrealm.Namespace, c.secretName))
+				continue
+			case err != nil:
+				return nil, nil, nil, err
+			}
+			connectorSecret := newConnectorSecret(authrealm, idp.Name, secret)
+			c.connector.Config.ClientSecretRef = connectorSecret.Name
+			secrets = append(secrets, connectorSecret)
+		}
+		connectors = append(connectors, c.connector)
+	}
+	return connectors, secrets, problems, nil
+}
+
+// newConnectorSecret returns the copy in the Dex namespace of the secret referenced by an IdentityProvider
+func newConnectorSecret(authrealm *identitatemv1alpha1.AuthRealm, idpName string, hubSecret *corev1.Secret) *corev1.Secret {
+	labels := helpers.OwnerLabels(authrealm, "", "")
+	labels[DexConnectorLabel] = idpName
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        helpers.ConnectorSecretName(authrealm, idpName),
+			Namespace:   helpers.DexNamespace(authrealm),
+			Labels:      labels,
+			Annotations: helpers.OwnerAnnotations(authrealm),
+		},
+		Type: hubSecret.Type,
+		Data: map[string][]byte{},
+	}
+	for k, v := range hubSecret.Data {
+		secret.Data[k] = v
+	}
+	for k, v := range hubSecret.StringData {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+// applyDexConnectors applies the connector secrets and the connectors of the DexServer
+// and deletes the connector secrets of the removed IdentityProviders.
+// Only the connectors of the DexServer are owned by the strategy controller, the DexServer itself is not created.
+func (r *StrategyReconciler) applyDexConnectors(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	dexServer *identitatemdexv1alpha1.DexServer,
+	connectors []identitatemdexv1alpha1.ConnectorSpec,
+	secrets []*corev1.Secret) error {
+	keep := make(map[string]bool)
+	for _, secret := range secrets {
+		if err := helpers.Apply(ctx, r.Client, secret, helpers.StrategyFieldManager); err != nil {
+			return err
+		}
+		keep[secret.Name] = true
+	}
+	if err := r.pruneConnectorSecrets(ctx, authrealm, keep); err != nil {
+		return err
+	}
+
+	items := make([]interface{}, len(connectors))
+	for i := range connectors {
+		item, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&connectors[i])
+		if err != nil {
+			return err
+		}
+		items[i] = item
+	}
+	return helpers.ApplyFields(ctx, r.Client,
+		identitatemdexv1alpha1.GroupVersion.WithKind("DexServer"),
+		client.ObjectKeyFromObject(dexServer),
+		map[string]interface{}{
+			"spec": map[string]interface{}{
+				"connectors": items,
+			},
+		},
+		helpers.StrategyFieldManager)
+}
+
+// pruneConnectorSecrets deletes the connector secrets of the authrealm in the Dex namespace which are not kept
+func (r *StrategyReconciler) pruneConnectorSecrets(ctx context.Context,
+	authrealm *identitatemv1alpha1.AuthRealm,
+	keep map[string]bool) error {
+	existing := &corev1.SecretList{}
+	if err := r.Client.List(ctx, existing, client.InNamespace(helpers.DexNamespace(authrealm)),
+		client.MatchingLabels(helpers.AuthRealmLabels(authrealm)),
+		client.HasLabels{DexConnectorLabel}); err != nil {
+		return err
+	}
+	for i := range existing.Items {
+		if keep[existing.Items[i].Name] {
+			continue
+		}
+		if err := r.Client.Delete(ctx, &existing.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDexConnectorsReadyCondition reports on the strategy the configuration of the Dex connectors,
+// conflict tells why the Dex namespace can't be used, empty if it can
+func (r *StrategyReconciler) setDexConnectorsReadyCondition(ctx context.Context, strategy *identitatemv1alpha1.Strategy,
+	dexServer *identitatemdexv1alpha1.DexServer,
+	conflict string,
+	problems []string) error {
+	condition := metav1.Condition{
+		Type:   helpers.DexConnectorsReadyCondition,
+		Status: metav1.ConditionTrue,
+		Reason: "Applied",
+	}
+	switch {
+	case len(conflict) != 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "DexNamespaceConflict"
+		condition.Message = conflict
+	case dexServer == nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "DexServerNotFound"
+		condition.Message = "the DexServer of the authrealm is not found"
+	case len(problems) != 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidIdentityProviders"
+		condition.Message = strings.Join(problems, "; ")
+	default:
+		condition.Message = fmt.Sprintf("connectors of dexserver %s/%s configured", dexServer.Namespace, dexServer.Name)
+	}
+	return helpers.SetStrategyCondition(ctx, r.Client, strategy, condition)
+}
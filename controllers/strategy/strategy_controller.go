@@ -5,10 +5,10 @@ package strategy
 import (
 	"context"
 	"fmt"
-
-	// "time"
+	"time"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,8 +20,11 @@ import (
 	// "k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/go-logr/logr"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
@@ -34,14 +37,9 @@ import (
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	workv1 "open-cluster-management.io/api/work/v1"
 
-	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
-	// to ensure that exec-entrypoint and run can make use of them.
-	idpconfig "github.com/identitatem/idp-client-api/config"
-
 	//+kubebuilder:scaffold:imports
 
 	// clusteradmhelpers "open-cluster-management.io/clusteradm/pkg/helpers"
-	clusteradmapply "open-cluster-management.io/clusteradm/pkg/helpers/apply"
 
 	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
 )
@@ -54,12 +52,21 @@ type StrategyReconciler struct {
 	APIExtensionClient apiextensionsclient.Interface
 	Log                logr.Logger
 	Scheme             *runtime.Scheme
+	// ReconcileTimeout is the deadline of a reconcile, no deadline if zero
+	ReconcileTimeout time.Duration
+	// ControllerOptions sets the concurrency and the rate limiter of the controller
+	ControllerOptions controller.Options
+	// DefaultStrategy is the type of the Strategies which don't set one
+	DefaultStrategy identitatemv1alpha1.StrategyType
 }
 
+// +kubebuilder:rbac:groups="",resources={configmaps,secrets},verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=auth.identitatem.io,resources={dexservers},verbs=get;list;watch;patch
+
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources={authrealms,strategies},verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=identityconfig.identitatem.io,resources=strategies/finalizers,verbs=update
-// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list;create;update;patch;delete
+// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources={customresourcedefinitions},verbs=get;list
 
 //+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources={placements},verbs=get;list;watch;create;update;patch;delete
 
@@ -73,7 +80,11 @@ type StrategyReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *StrategyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
 	_ = r.Log.WithValues("strategy", req.NamespacedName)
 
 	// your logic here
@@ -81,7 +92,7 @@ func (r *StrategyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	instance := &identitatemv1alpha1.Strategy{}
 
 	if err := r.Client.Get(
-		context.TODO(),
+		ctx,
 		types.NamespacedName{Namespace: req.Namespace, Name: req.Name},
 		instance,
 	); err != nil {
@@ -95,13 +106,15 @@ func (r *StrategyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return reconcile.Result{}, err
 	}
 
+	helpers.SetDefaultStrategyType(instance, r.DefaultStrategy)
+
 	r.Log.Info("Instance", "instance", instance)
 	r.Log.Info("Running Reconcile for Strategy.", "Name: ", instance.GetName(), " Namespace:", instance.GetNamespace())
 
 	// Get the AuthRealm Placement bits we need to help create a new Placement
 
 	r.Log.Info("Searching for AuthRealm in ownerRefs", "strategy", instance.Name)
-	authrealm, err := helpers.GetAuthrealmFromStrategy(r.Client, instance)
+	authrealm, err := helpers.GetAuthrealmFromStrategy(ctx, r.Client, instance)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -113,12 +126,12 @@ func (r *StrategyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	//Check if there is a predicate to add, if not nothing to do
 
 	placement := &clusterv1alpha1.Placement{}
-	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: authrealm.Spec.PlacementRef.Name, Namespace: req.Namespace}, placement); err != nil {
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: authrealm.Spec.PlacementRef.Name, Namespace: req.Namespace}, placement); err != nil {
 		return reconcile.Result{}, err
 	}
 
 	//Get placementStrategy
-	placementStrategy, placementStrategyExists, err := r.getStrategyPlacement(instance, authrealm, placement)
+	placementStrategy, err := r.newStrategyPlacement(instance, authrealm, placement)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -137,53 +150,119 @@ func (r *StrategyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return reconcile.Result{}, fmt.Errorf("strategy type %s not supported", instance.Spec.Type)
 	}
 
-	//Create or update placementStrategy
-	switch placementStrategyExists {
-	case true:
-		if err := r.Client.Update(context.TODO(), placementStrategy); err != nil {
+	//Translate the IdentityProviders of the AuthRealm into the connectors of its DexServer.
+	//The connectors are not applied in a Dex namespace owned by another AuthRealm with the same name
+	//as the DexServers of the namespace serve all its DexClients.
+	conflict, err := helpers.GetDexNamespaceConflict(ctx, r.Client, authrealm)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	dexServer, err := r.getDexServer(ctx, authrealm)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	issuer := ""
+	if dexServer != nil {
+		issuer = dexServer.Spec.Issuer
+	}
+	connectors, connectorSecrets, problems, err := r.buildDexConnectors(ctx, authrealm, issuer)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	//In dry-run the placementStrategy is still created as the decisions are needed to preview
+	//the resources generated for each cluster, nothing is applied on the clusters in dry-run.
+	if helpers.IsDryRun(instance) {
+		if err := helpers.UpdateDryRunConfigMap(ctx, r.Client, r.Scheme, instance, map[string]interface{}{
+			"placement.yaml": &clusterv1alpha1.Placement{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: clusterv1alpha1.GroupVersion.String(),
+					Kind:       "Placement",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      placementStrategy.Name,
+					Namespace: placementStrategy.Namespace,
+				},
+				Spec: placementStrategy.Spec,
+			},
+			"dexconnectors.yaml": connectors,
+		}, helpers.StrategyFieldManager); err != nil {
 			return reconcile.Result{}, err
 		}
-	case false:
-		if err := r.Client.Create(context.Background(), placementStrategy); err != nil {
+	} else {
+		if err := helpers.DeleteDryRunConfigMap(ctx, r.Client, instance); err != nil {
 			return reconcile.Result{}, err
 		}
+		switch {
+		case len(conflict) != 0 && dexServer != nil:
+			//The connectors are cleared so the DexServer can't authenticate the DexClients of the namespace
+			if err := r.applyDexConnectors(ctx, authrealm, dexServer, nil, nil); err != nil {
+				return reconcile.Result{}, err
+			}
+		case len(conflict) != 0:
+			if err := r.pruneConnectorSecrets(ctx, authrealm, nil); err != nil {
+				return reconcile.Result{}, err
+			}
+		case dexServer != nil:
+			if err := r.applyDexConnectors(ctx, authrealm, dexServer, connectors, connectorSecrets); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
+
+	//Create or update placementStrategy
+	if err := helpers.Apply(ctx, r.Client, placementStrategy, helpers.StrategyFieldManager); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// report the Placement ref in the status, the spec is owned by the user
+	if err := r.setPlacementReadyCondition(ctx, instance, placementStrategy); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// update the Placement ref
-	instance.Spec.PlacementRef.Name = placementStrategy.Name
-	if err := r.Client.Update(context.TODO(), instance); err != nil {
+	if err := r.setDexConnectorsReadyCondition(ctx, instance, dexServer, conflict, problems); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *StrategyReconciler) getStrategyPlacement(strategy *identitatemv1alpha1.Strategy,
+// newStrategyPlacement returns the placementStrategy to apply, a copy of the AuthRealm placement owned by the strategy
+func (r *StrategyReconciler) newStrategyPlacement(strategy *identitatemv1alpha1.Strategy,
 	authrealm *identitatemv1alpha1.AuthRealm,
-	placement *clusterv1alpha1.Placement) (*clusterv1alpha1.Placement, bool, error) {
-	placementStrategy := &clusterv1alpha1.Placement{}
-	placementStrategyExists := true
-	placementStrategyName := getPlacementStrategyName(strategy, authrealm)
-	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: placementStrategyName, Namespace: strategy.Namespace}, placementStrategy); err != nil {
-		if !errors.IsNotFound(err) {
-			return nil, false, err
-		}
-		placementStrategyExists = false
-		// Not Found! Create
-		placementStrategy = &clusterv1alpha1.Placement{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: strategy.Namespace,
-				//DV The name is given by the authrealm as the user will define the binding with the clusterset
-				//Name:      req.Name,
-				Name: placementStrategyName,
-			},
-			//DV move below
-			Spec: placement.Spec,
-		}
-		// Set owner reference for cleanup
-		controllerutil.SetOwnerReference(strategy, placementStrategy, r.Scheme)
+	placement *clusterv1alpha1.Placement) (*clusterv1alpha1.Placement, error) {
+	placementStrategy := &clusterv1alpha1.Placement{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clusterv1alpha1.GroupVersion.String(),
+			Kind:       "Placement",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: strategy.Namespace,
+			//DV The name is given by the authrealm as the user will define the binding with the clusterset
+			//Name:      req.Name,
+			Name:        getPlacementStrategyName(strategy, authrealm),
+			Labels:      helpers.OwnerLabels(authrealm, strategy.Name, ""),
+			Annotations: helpers.OwnerAnnotations(authrealm),
+		},
+		//DV move below
+		Spec: *placement.Spec.DeepCopy(),
 	}
-	return placementStrategy, placementStrategyExists, nil
+	// Set owner reference for cleanup
+	if err := controllerutil.SetOwnerReference(strategy, placementStrategy, r.Scheme); err != nil {
+		return nil, err
+	}
+	return placementStrategy, nil
+}
+
+// setPlacementReadyCondition references the placementStrategy in the status of the strategy
+func (r *StrategyReconciler) setPlacementReadyCondition(ctx context.Context, strategy *identitatemv1alpha1.Strategy,
+	placementStrategy *clusterv1alpha1.Placement) error {
+	return helpers.SetStrategyCondition(ctx, r.Client, strategy, metav1.Condition{
+		Type:    helpers.PlacementReadyCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Applied",
+		Message: fmt.Sprintf("placement %s/%s generated", placementStrategy.Namespace, placementStrategy.Name),
+	})
 }
 
 func getPlacementStrategyName(strategy *identitatemv1alpha1.Strategy,
@@ -193,22 +272,6 @@ func getPlacementStrategyName(strategy *identitatemv1alpha1.Strategy,
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *StrategyReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	//Install CRD
-	applierBuilder := &clusteradmapply.ApplierBuilder{}
-	applier := applierBuilder.WithClient(r.KubeClient, r.APIExtensionClient, r.DynamicClient).Build()
-
-	readerIDPMgmtOperator := idpconfig.GetScenarioResourcesReader()
-
-	file := "crd/bases/identityconfig.identitatem.io_strategies.yaml"
-	if _, err := applier.ApplyDirectly(readerIDPMgmtOperator, nil, false, "", file); err != nil {
-		return err
-	}
-
-	// b := retry.DefaultBackoff
-	// b.Duration = 10 * time.Second
-	// if err := clusteradmhelpers.WaitCRDToBeReady(r.APIExtensionClient, "strategies.identityconfig.identiatem.io", b); err != nil {
-	// 	return err
-	// }
 	if err := identitatemv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
 		return err
 	}
@@ -236,5 +299,91 @@ func (r *StrategyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&identitatemv1alpha1.Strategy{}).
 		Owns(&clusterv1alpha1.Placement{}).
+		Watches(&source.Kind{Type: &identitatemv1alpha1.AuthRealm{}},
+			handler.EnqueueRequestsFromMapFunc(r.authRealmRequest)).
+		Watches(&source.Kind{Type: &identitatemdexv1alpha1.DexServer{}},
+			handler.EnqueueRequestsFromMapFunc(r.dexServerStrategiesRequest)).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.connectorSecretStrategiesRequest)).
+		WithOptions(r.ControllerOptions).
 		Complete(r)
 }
+
+// authRealmStrategiesRequest maps an AuthRealm to the reconcile requests of its Strategies,
+// so the changes of the AuthRealm are reconciled
+func (r *StrategyReconciler) authRealmStrategiesRequest(o client.Object) []reconcile.Request {
+	strategies := &identitatemv1alpha1.StrategyList{}
+	if err := r.Client.List(context.TODO(), strategies, client.InNamespace(o.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list the Strategies", "namespace", o.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, strategy := range strategies.Items {
+		for _, ownerRef := range strategy.GetOwnerReferences() {
+			if ownerRef.Kind == "AuthRealm" && ownerRef.Name == o.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: strategy.Name, Namespace: strategy.Namespace},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// authRealmRequest maps an AuthRealm to the reconcile requests of its Strategies and of the Strategies
+// of the AuthRealms with the same name, so they take the Dex namespace over once the AuthRealm owning it is deleted
+func (r *StrategyReconciler) authRealmRequest(o client.Object) []reconcile.Request {
+	requests := r.authRealmStrategiesRequest(o)
+	authrealms := &identitatemv1alpha1.AuthRealmList{}
+	if err := r.Client.List(context.TODO(), authrealms); err != nil {
+		r.Log.Error(err, "unable to list the AuthRealms")
+		return requests
+	}
+	for i := range authrealms.Items {
+		if authrealms.Items[i].Name == o.GetName() && authrealms.Items[i].Namespace != o.GetNamespace() {
+			requests = append(requests, r.authRealmStrategiesRequest(&authrealms.Items[i])...)
+		}
+	}
+	return requests
+}
+
+// dexServerStrategiesRequest maps a DexServer to the reconcile requests of the Strategies
+// of the AuthRealms it serves, the Dex namespace is named after the AuthRealms
+func (r *StrategyReconciler) dexServerStrategiesRequest(o client.Object) []reconcile.Request {
+	authrealms := &identitatemv1alpha1.AuthRealmList{}
+	if err := r.Client.List(context.TODO(), authrealms); err != nil {
+		r.Log.Error(err, "unable to list the AuthRealms")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range authrealms.Items {
+		authrealm := &authrealms.Items[i]
+		if helpers.DexNamespace(authrealm) != o.GetNamespace() || helpers.DexServerName(authrealm) != o.GetName() {
+			continue
+		}
+		requests = append(requests, r.authRealmStrategiesRequest(authrealm)...)
+	}
+	return requests
+}
+
+// connectorSecretStrategiesRequest maps a secret referenced by an IdentityProvider of the AuthRealms
+// of its namespace to the reconcile requests of their Strategies, so the secret changes are delivered to Dex
+func (r *StrategyReconciler) connectorSecretStrategiesRequest(o client.Object) []reconcile.Request {
+	authrealms := &identitatemv1alpha1.AuthRealmList{}
+	if err := r.Client.List(context.TODO(), authrealms, client.InNamespace(o.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list the AuthRealms", "namespace", o.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range authrealms.Items {
+		authrealm := &authrealms.Items[i]
+		for j := range authrealm.Spec.IdentityProviders {
+			if c, problem := newDexConnector(&authrealm.Spec.IdentityProviders[j], ""); len(problem) == 0 && c.secretName == o.GetName() {
+				requests = append(requests, r.authRealmStrategiesRequest(authrealm)...)
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -14,7 +14,10 @@ import (
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -24,6 +27,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	identitatemdexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
 	dexoperatorconfig "github.com/identitatem/dex-operator/config"
@@ -35,6 +39,8 @@ import (
 
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	clusteradmasset "open-cluster-management.io/clusteradm/pkg/helpers/asset"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -250,14 +256,289 @@ var _ = Describe("Process Strategy backplane: ", func() {
 			var err error
 			strategy, err = clientSetStrategy.IdentityconfigV1alpha1().Strategies(AuthRealmNameSpace).Get(context.TODO(), StrategyName, metav1.GetOptions{})
 			Expect(err).To(BeNil())
-			Expect(strategy.Spec.PlacementRef.Name).Should(Equal(PlacementStrategyName))
+			Expect(strategy.Spec.PlacementRef.Name).Should(BeEmpty())
+			condition := meta.FindStatusCondition(strategy.Status.Conditions, helpers.PlacementReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Message).Should(ContainSubstring(PlacementStrategyName))
 		})
 		By("Checking placement strategy", func() {
-			_, err := clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+			placementStrategy, err := clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
 				Get(context.TODO(), PlacementStrategyName, metav1.GetOptions{})
 			Expect(err).To(BeNil())
+			Expect(placementStrategy.Labels).To(HaveKeyWithValue(helpers.StrategyLabel, StrategyName))
 			Expect(len(placement.Spec.Predicates)).Should(Equal(1))
 		})
+		By("Checking the fields set by another actor are kept", func() {
+			placementStrategy, err := clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Get(context.TODO(), PlacementStrategyName, metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			placementStrategy.Labels["team"] = "identity"
+			_, err = clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Update(context.TODO(), placementStrategy, metav1.UpdateOptions{})
+			Expect(err).To(BeNil())
+
+			r := StrategyReconciler{
+				Client: k8sClient,
+				Log:    logf.Log,
+				Scheme: scheme.Scheme,
+			}
+			req := ctrl.Request{}
+			req.Name = StrategyName
+			req.Namespace = AuthRealmNameSpace
+			_, err = r.Reconcile(context.TODO(), req)
+			Expect(err).To(BeNil())
+
+			placementStrategy, err = clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
+				Get(context.TODO(), PlacementStrategyName, metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(placementStrategy.Labels).To(HaveKeyWithValue("team", "identity"))
+			managers := make([]string, 0)
+			for _, managedField := range placementStrategy.GetManagedFields() {
+				managers = append(managers, managedField.Manager)
+			}
+			Expect(managers).To(ContainElement(helpers.StrategyFieldManager))
+		})
+	})
+})
+
+var _ = Describe("Configure the Dex connectors: ", func() {
+	AuthRealmName := "my-authrealm-dex"
+	AuthRealmNameSpace := "my-authrealmns-dex"
+	StrategyName := AuthRealmName + "-backplane"
+	PlacementName := AuthRealmName
+	MyGitHubName := "my-github"
+	MyLDAPName := "my-ldap"
+	DexServerName := helpers.DexServerName(&identitatemv1alpha1.AuthRealm{
+		ObjectMeta: metav1.ObjectMeta{Name: AuthRealmName, Namespace: AuthRealmNameSpace},
+	})
+
+	r := StrategyReconciler{
+		Client: k8sClient,
+		Log:    logf.Log,
+		Scheme: scheme.Scheme,
+	}
+	reconcileStrategy := func() {
+		req := ctrl.Request{}
+		req.Name = StrategyName
+		req.Namespace = AuthRealmNameSpace
+		_, err := r.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+	}
+	getDexServer := func() *identitatemdexv1alpha1.DexServer {
+		dexServer := &identitatemdexv1alpha1.DexServer{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: DexServerName, Namespace: AuthRealmName}, dexServer)
+		Expect(err).To(BeNil())
+		return dexServer
+	}
+
+	It("translates the identity providers of the AuthRealm into Dex connectors", func() {
+		By("creation of the AuthRealm and Dex namespaces", func() {
+			for _, name := range []string{AuthRealmNameSpace, AuthRealmName} {
+				err := k8sClient.Create(context.TODO(), &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+				})
+				Expect(err).To(BeNil())
+			}
+		})
+		By("creation of the placement, the DexServer and the GitHub client secret", func() {
+			err := k8sClient.Create(context.TODO(), &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{Name: PlacementName, Namespace: AuthRealmNameSpace},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &identitatemdexv1alpha1.DexServer{
+				ObjectMeta: metav1.ObjectMeta{Name: DexServerName, Namespace: AuthRealmName},
+				Spec: identitatemdexv1alpha1.DexServerSpec{
+					Issuer: "https://dex.example.com",
+				},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "github-client", Namespace: AuthRealmNameSpace},
+				StringData: map[string]string{"clientSecret": "secret"},
+			})
+			Expect(err).To(BeNil())
+		})
+		var authRealm *identitatemv1alpha1.AuthRealm
+		By("creation of the AuthRealm and its Strategy", func() {
+			authRealm = &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{Name: AuthRealmName, Namespace: AuthRealmNameSpace},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+					IdentityProviders: []openshiftconfigv1.IdentityProvider{
+						{
+							Name:          MyGitHubName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+								GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+									ClientID:      "me",
+									ClientSecret:  openshiftconfigv1.SecretNameReference{Name: "github-client"},
+									Organizations: []string{"my-org"},
+								},
+							},
+						},
+						{
+							Name:          MyLDAPName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeLDAP,
+								LDAP: &openshiftconfigv1.LDAPIdentityProvider{
+									URL:          "ldaps://ldap.example.com/ou=users,dc=example,dc=com?uid",
+									BindDN:       "cn=admin",
+									BindPassword: openshiftconfigv1.SecretNameReference{Name: "ldap-bind"},
+								},
+							},
+						},
+					},
+					PlacementRef: corev1.LocalObjectReference{Name: PlacementName},
+				},
+			}
+			err := k8sClient.Create(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{Name: StrategyName, Namespace: AuthRealmNameSpace},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			controllerutil.SetOwnerReference(authRealm, strategy, scheme.Scheme)
+			err = k8sClient.Create(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			Expect(r.authRealmStrategiesRequest(authRealm)).To(HaveLen(1))
+		})
+		By("Checking the connectors and the LDAP identity provider which can't be translated", func() {
+			reconcileStrategy()
+			dexServer := getDexServer()
+			Expect(dexServer.Spec.Issuer).To(Equal("https://dex.example.com"))
+			Expect(dexServer.Spec.Connectors).To(HaveLen(1))
+			connector := dexServer.Spec.Connectors[0]
+			Expect(connector.Id).To(Equal(MyGitHubName))
+			Expect(connector.Type).To(Equal("github"))
+			Expect(connector.Config.ClientID).To(Equal("me"))
+			Expect(connector.Config.Org).To(Equal("my-org"))
+			Expect(connector.Config.RedirectURI).To(Equal("https://dex.example.com/callback"))
+			Expect(connector.Config.ClientSecretRef).To(Equal(helpers.ConnectorSecretName(authRealm, MyGitHubName)))
+
+			secret := &corev1.Secret{}
+			err := k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: connector.Config.ClientSecretRef, Namespace: AuthRealmName}, secret)
+			Expect(err).To(BeNil())
+			Expect(string(secret.Data["clientSecret"])).To(Equal("secret"))
+			Expect(secret.Labels).To(HaveKeyWithValue(DexConnectorLabel, MyGitHubName))
+
+			strategy := &identitatemv1alpha1.Strategy{}
+			err = k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+			Expect(err).To(BeNil())
+			condition := meta.FindStatusCondition(strategy.Status.Conditions, helpers.DexConnectorsReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring(MyLDAPName))
+
+			Expect(r.connectorSecretStrategiesRequest(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "github-client", Namespace: AuthRealmNameSpace},
+			})).To(HaveLen(1))
+			Expect(r.connectorSecretStrategiesRequest(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "ldap-bind", Namespace: AuthRealmNameSpace},
+			})).To(BeEmpty())
+		})
+		By("Removing the GitHub identity provider", func() {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(authRealm), authRealm)
+			Expect(err).To(BeNil())
+			authRealm.Spec.IdentityProviders = authRealm.Spec.IdentityProviders[1:]
+			err = k8sClient.Update(context.TODO(), authRealm)
+			Expect(err).To(BeNil())
+
+			reconcileStrategy()
+			dexServer := getDexServer()
+			Expect(dexServer.Spec.Connectors).To(BeEmpty())
+
+			strategy := &identitatemv1alpha1.Strategy{}
+			err = k8sClient.Get(context.TODO(), client.ObjectKey{Name: StrategyName, Namespace: AuthRealmNameSpace}, strategy)
+			Expect(err).To(BeNil())
+			condition := meta.FindStatusCondition(strategy.Status.Conditions, helpers.DexConnectorsReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring(MyLDAPName))
+			Expect(condition.Message).To(ContainSubstring("ldap.example.com"))
+
+			err = k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.ConnectorSecretName(authRealm, MyGitHubName), Namespace: AuthRealmName}, &corev1.Secret{})
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+		})
+		By("Checking an AuthRealm with the same name in another namespace gets no connectors", func() {
+			otherNameSpace := AuthRealmNameSpace + "-2"
+			err := k8sClient.Create(context.TODO(), &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: otherNameSpace},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &clusterv1alpha1.Placement{
+				ObjectMeta: metav1.ObjectMeta{Name: PlacementName, Namespace: otherNameSpace},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "github-client", Namespace: otherNameSpace},
+				StringData: map[string]string{"clientSecret": "secret"},
+			})
+			Expect(err).To(BeNil())
+			other := &identitatemv1alpha1.AuthRealm{
+				ObjectMeta: metav1.ObjectMeta{Name: AuthRealmName, Namespace: otherNameSpace},
+				Spec: identitatemv1alpha1.AuthRealmSpec{
+					Type: identitatemv1alpha1.AuthProxyDex,
+					IdentityProviders: []openshiftconfigv1.IdentityProvider{
+						{
+							Name:          MyGitHubName,
+							MappingMethod: openshiftconfigv1.MappingMethodClaim,
+							IdentityProviderConfig: openshiftconfigv1.IdentityProviderConfig{
+								Type: openshiftconfigv1.IdentityProviderTypeGitHub,
+								GitHub: &openshiftconfigv1.GitHubIdentityProvider{
+									ClientID:     "me",
+									ClientSecret: openshiftconfigv1.SecretNameReference{Name: "github-client"},
+								},
+							},
+						},
+					},
+					PlacementRef: corev1.LocalObjectReference{Name: PlacementName},
+				},
+			}
+			err = k8sClient.Create(context.TODO(), other)
+			Expect(err).To(BeNil())
+			otherDexServer := &identitatemdexv1alpha1.DexServer{
+				ObjectMeta: metav1.ObjectMeta{Name: helpers.DexServerName(other), Namespace: AuthRealmName},
+				Spec: identitatemdexv1alpha1.DexServerSpec{
+					Issuer: "https://dex-2.example.com",
+				},
+			}
+			err = k8sClient.Create(context.TODO(), otherDexServer)
+			Expect(err).To(BeNil())
+			strategy := &identitatemv1alpha1.Strategy{
+				ObjectMeta: metav1.ObjectMeta{Name: StrategyName, Namespace: otherNameSpace},
+				Spec: identitatemv1alpha1.StrategySpec{
+					Type: identitatemv1alpha1.BackplaneStrategyType,
+				},
+			}
+			controllerutil.SetOwnerReference(other, strategy, scheme.Scheme)
+			err = k8sClient.Create(context.TODO(), strategy)
+			Expect(err).To(BeNil())
+			Expect(r.authRealmRequest(authRealm)).To(ContainElement(reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: StrategyName, Namespace: otherNameSpace},
+			}))
+
+			_, err = r.Reconcile(context.TODO(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: StrategyName, Namespace: otherNameSpace},
+			})
+			Expect(err).To(BeNil())
+			err = k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(otherDexServer), otherDexServer)
+			Expect(err).To(BeNil())
+			Expect(otherDexServer.Spec.Connectors).To(BeEmpty())
+			err = k8sClient.Get(context.TODO(),
+				client.ObjectKey{Name: helpers.ConnectorSecretName(other, MyGitHubName), Namespace: AuthRealmName}, &corev1.Secret{})
+			Expect(kerrors.IsNotFound(err)).To(BeTrue())
+			err = k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(strategy), strategy)
+			Expect(err).To(BeNil())
+			condition := meta.FindStatusCondition(strategy.Status.Conditions, helpers.DexConnectorsReadyCondition)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Reason).To(Equal("DexNamespaceConflict"))
+		})
 	})
 })
 
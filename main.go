@@ -3,8 +3,13 @@
 package main
 
 import (
+	"context"
+	_ "embed"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -13,17 +18,30 @@ import (
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
+	dexoperatorv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
 	identitatemiov1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
+	routev1 "github.com/openshift/api/route/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	configv1alpha1 "github.com/identitatem/idp-strategy-operator/api/config/v1alpha1"
 	"github.com/identitatem/idp-strategy-operator/controllers/clusteroauth"
+	"github.com/identitatem/idp-strategy-operator/controllers/garbagecollector"
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
 	"github.com/identitatem/idp-strategy-operator/controllers/placementdecision"
 	"github.com/identitatem/idp-strategy-operator/controllers/strategy"
+	"github.com/identitatem/idp-strategy-operator/pkg/install"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -36,18 +54,91 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(identitatemiov1alpha1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "install" || os.Args[1] == "upgrade") {
+		if err := runInstall(os.Args[1], os.Args[2:]); err != nil {
+			setupLog.Error(err, "unable to install the CRDs")
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var rollbackWindow time.Duration
+	var breakGlass bool
+	var reconcileTimeout time.Duration
+	var strategyConcurrency int
+	var placementDecisionConcurrency int
+	var clusterOAuthConcurrency int
+	var rateLimiterBaseDelay time.Duration
+	var rateLimiterMaxDelay time.Duration
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var configFile string
+	var syncPeriod time.Duration
+	var defaultStrategy string
+	var redirectTemplate string
+	var rotationPeriod time.Duration
+	var controllers string
+	var livenessTimeout time.Duration
+	var gcInterval time.Duration
+	var leaderElectionIDFlag string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionIDFlag, "leader-election-id", "",
+		"The name of the leader election lease. By default the name of the config file or "+defaultLeaderElectionID+
+			" prefixed with the enabled controllers when not all are enabled, so the deployments running different controllers "+
+			"elect their own leader.")
+	flag.DurationVar(&rollbackWindow, "rollback-window", 10*time.Minute,
+		"The duration after an update of the OAuth ManifestWork during which a failure on the managed cluster "+
+			"rolls it back to the last known-good revision. Set to 0 to disable the automatic rollback.")
+	flag.BoolVar(&breakGlass, "break-glass", false,
+		"Add a break-glass htpasswd identity provider with a cluster-admin user to the OAuth of every managed cluster.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 2*time.Minute,
+		"The maximum duration of a reconcile, the API calls still running are cancelled. Set to 0 to disable.")
+	flag.IntVar(&strategyConcurrency, "strategy-concurrency", 1,
+		"The number of Strategies reconciled concurrently.")
+	flag.IntVar(&placementDecisionConcurrency, "placementdecision-concurrency", 1,
+		"The number of Placements whose decisions are reconciled concurrently.")
+	flag.IntVar(&clusterOAuthConcurrency, "clusteroauth-concurrency", 10,
+		"The number of managed clusters whose OAuth is reconciled concurrently.")
+	flag.DurationVar(&rateLimiterBaseDelay, "rate-limiter-base-delay", 5*time.Millisecond,
+		"The delay before retrying a failed reconcile, doubled on each consecutive failure.")
+	flag.DurationVar(&rateLimiterMaxDelay, "rate-limiter-max-delay", 1000*time.Second,
+		"The maximum delay before retrying a failed reconcile.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20,
+		"The maximum queries per second from the controllers to the kube API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30,
+		"The maximum burst of queries from the controllers to the kube API server.")
+	flag.StringVar(&configFile, "config", "",
+		"The OperatorConfig file of the operator. The flags set on the command line override the values of the file.")
+	flag.DurationVar(&syncPeriod, "sync-period", 10*time.Hour,
+		"The period at which all the watched resources are reconciled again.")
+	flag.StringVar(&defaultStrategy, "default-strategy", string(identitatemiov1alpha1.BackplaneStrategyType),
+		"The strategy type of the Strategies which don't set one.")
+	flag.StringVar(&redirectTemplate, "redirect-template", placementdecision.DefaultRedirectTemplate,
+		"The Go template of the redirect URI of the DexClients, "+
+			"it receives the .Scheme and .AppsHost of the hub, the .ClusterName and the .IdentityProvider name.")
+	flag.DurationVar(&rotationPeriod, "rotation-period", 0,
+		"The maximum age of the generated client secrets. Set to 0 to never rotate them.")
+	flag.StringVar(&controllers, "controllers", "*",
+		"The comma-separated list of the controllers to run among "+strings.Join(allControllers, ", ")+
+			". '*' runs all the controllers, '-name' disables the controller name.")
+	flag.DurationVar(&livenessTimeout, "liveness-timeout", 10*time.Minute,
+		"The maximum duration without completed reconcile of a controller having requests queued before it is reported unhealthy. "+
+			"Set to 0 to disable.")
+	flag.DurationVar(&gcInterval, "gc-interval", 10*time.Minute,
+		"The period at which the generated resources whose AuthRealm or Strategy no longer exists are deleted. "+
+			"Set to 0 to disable.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -56,58 +147,171 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "cc3e3fdf.identitatem.io",
-	})
+	cfg := ctrl.GetConfigOrDie()
+	cfg.QPS = float32(kubeAPIQPS)
+	cfg.Burst = kubeAPIBurst
+
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	options := ctrl.Options{Scheme: scheme}
+	operatorConfig := configv1alpha1.OperatorConfig{}
+	if len(configFile) != 0 {
+		var err error
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&operatorConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file", "config", configFile)
+			os.Exit(1)
+		}
+	}
+
+	//The flags set on the command line override the config file,
+	//the flag defaults are used for the values missing in the file
+	if setFlags["metrics-bind-address"] || len(options.MetricsBindAddress) == 0 {
+		options.MetricsBindAddress = metricsAddr
+	}
+	if setFlags["health-probe-bind-address"] || len(options.HealthProbeBindAddress) == 0 {
+		options.HealthProbeBindAddress = probeAddr
+	}
+	if setFlags["leader-elect"] {
+		options.LeaderElection = enableLeaderElection
+	}
+	if options.Port == 0 {
+		options.Port = 9443
+	}
+	if setFlags["sync-period"] {
+		options.SyncPeriod = &syncPeriod
+	}
+	if setFlags["default-strategy"] || len(operatorConfig.DefaultStrategy) == 0 {
+		operatorConfig.DefaultStrategy = identitatemiov1alpha1.StrategyType(defaultStrategy)
+	}
+	if setFlags["redirect-template"] || len(operatorConfig.RedirectTemplate) == 0 {
+		operatorConfig.RedirectTemplate = redirectTemplate
+	}
+	if setFlags["rotation-period"] || operatorConfig.RotationPeriod == nil {
+		operatorConfig.RotationPeriod = &metav1.Duration{Duration: rotationPeriod}
+	}
+
+	redirectURITemplate, err := placementdecision.ParseRedirectTemplate(operatorConfig.RedirectTemplate)
+	if err != nil {
+		setupLog.Error(err, "invalid redirect template", "redirectTemplate", operatorConfig.RedirectTemplate)
+		os.Exit(1)
+	}
+
+	enabledControllers, err := parseControllers(controllers)
+	if err != nil {
+		setupLog.Error(err, "invalid controllers", "controllers", controllers)
+		os.Exit(1)
+	}
+
+	if setFlags["leader-election-id"] {
+		options.LeaderElectionID = leaderElectionIDFlag
+	} else {
+		if len(options.LeaderElectionID) == 0 {
+			options.LeaderElectionID = defaultLeaderElectionID
+		}
+		options.LeaderElectionID = leaderElectionID(options.LeaderElectionID, enabledControllers)
+	}
+
+	mgr, err := ctrl.NewManager(cfg, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	//The clients are shared by the controllers
+	kubeClient := kubernetes.NewForConfigOrDie(cfg)
+	dynamicClient := dynamic.NewForConfigOrDie(cfg)
+	apiExtensionClient := apiextensionsclient.NewForConfigOrDie(cfg)
+
 	//This manager is in charge of creating a Placement per strategy
 	//based on the strategy and authrealm
-	if err = (&strategy.StrategyReconciler{
-		Client:             mgr.GetClient(),
-		KubeClient:         kubernetes.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		DynamicClient:      dynamic.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		APIExtensionClient: apiextensionsclient.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		Scheme:             mgr.GetScheme(),
-		Log:                ctrl.Log.WithName("controllers").WithName("Strategy"),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Strategy")
-		os.Exit(1)
+	if enabledControllers["strategy"] {
+		if err = (&strategy.StrategyReconciler{
+			Client:             mgr.GetClient(),
+			KubeClient:         kubeClient,
+			DynamicClient:      dynamicClient,
+			APIExtensionClient: apiExtensionClient,
+			Scheme:             mgr.GetScheme(),
+			ReconcileTimeout:   reconcileTimeout,
+			Log:                ctrl.Log.WithName("controllers").WithName("Strategy"),
+			DefaultStrategy:    operatorConfig.DefaultStrategy,
+			ControllerOptions:  helpers.NewControllerOptions(strategyConcurrency, rateLimiterBaseDelay, rateLimiterMaxDelay),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Strategy")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("strategy", helpers.NewAPIsChecker(mgr.GetRESTMapper(), controllerAPIs["strategy"]...)); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "controller", "Strategy")
+			os.Exit(1)
+		}
 	}
 
 	//This manager creates the DexClient and ClusterOAuth based on placementDecision
-	if err = (&placementdecision.PlacementDecisionReconciler{
-		Client:             mgr.GetClient(),
-		KubeClient:         kubernetes.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		DynamicClient:      dynamic.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		APIExtensionClient: apiextensionsclient.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		Scheme:             mgr.GetScheme(),
-		Log:                ctrl.Log.WithName("controllers").WithName("PlacementDecision"),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "PlacementDecision")
-		os.Exit(1)
+	if enabledControllers["placementdecision"] {
+		if err = (&placementdecision.PlacementDecisionReconciler{
+			Client:             mgr.GetClient(),
+			APIReader:          mgr.GetAPIReader(),
+			KubeClient:         kubeClient,
+			DynamicClient:      dynamicClient,
+			APIExtensionClient: apiExtensionClient,
+			Scheme:             mgr.GetScheme(),
+			ReconcileTimeout:   reconcileTimeout,
+			Log:                ctrl.Log.WithName("controllers").WithName("PlacementDecision"),
+			DefaultStrategy:    operatorConfig.DefaultStrategy,
+			RedirectTemplate:   redirectURITemplate,
+			RotationPeriod:     operatorConfig.RotationPeriod.Duration,
+			ControllerOptions:  helpers.NewControllerOptions(placementDecisionConcurrency, rateLimiterBaseDelay, rateLimiterMaxDelay),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PlacementDecision")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("placementdecision", helpers.NewAPIsChecker(mgr.GetRESTMapper(), controllerAPIs["placementdecision"]...)); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "controller", "PlacementDecision")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("hub-info", helpers.NewHubInfoChecker(mgr.GetClient())); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "controller", "PlacementDecision")
+			os.Exit(1)
+		}
 	}
 
 	//This manager consolidate all ClusterOAuth into one OAUth and
 	//send it to the managedcluster using the available strategy
-	if err = (&clusteroauth.ClusterOAuthReconciler{
-		Client:             mgr.GetClient(),
-		KubeClient:         kubernetes.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		DynamicClient:      dynamic.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		APIExtensionClient: apiextensionsclient.NewForConfigOrDie(ctrl.GetConfigOrDie()),
-		Scheme:             mgr.GetScheme(),
-		Log:                ctrl.Log.WithName("controllers").WithName("ClusterOAuth"),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ClusterOAuth")
-		os.Exit(1)
+	if enabledControllers["clusteroauth"] {
+		if err = (&clusteroauth.ClusterOAuthReconciler{
+			Client:             mgr.GetClient(),
+			KubeClient:         kubeClient,
+			DynamicClient:      dynamicClient,
+			APIExtensionClient: apiExtensionClient,
+			Scheme:             mgr.GetScheme(),
+			ReconcileTimeout:   reconcileTimeout,
+			Log:                ctrl.Log.WithName("controllers").WithName("ClusterOAuth"),
+			ControllerOptions:  helpers.NewControllerOptions(clusterOAuthConcurrency, rateLimiterBaseDelay, rateLimiterMaxDelay),
+			Recorder:           mgr.GetEventRecorderFor("clusteroauth-controller"),
+			RollbackWindow:     rollbackWindow,
+			BreakGlass:         breakGlass,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterOAuth")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("clusteroauth", helpers.NewAPIsChecker(mgr.GetRESTMapper(), controllerAPIs["clusteroauth"]...)); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "controller", "ClusterOAuth")
+			os.Exit(1)
+		}
+	}
+
+	//The garbage collector deletes the generated resources which can't have an owner reference,
+	//it runs in the deployment enabling it when the controllers are split in several deployments
+	if enabledControllers["garbagecollector"] && gcInterval > 0 {
+		if err := (&garbagecollector.GarbageCollector{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("garbagecollector"),
+			Interval: gcInterval,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create the garbage collector")
+			os.Exit(1)
+		}
 	}
 
 	//+kubebuilder:scaffold:builder
@@ -116,14 +320,168 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if livenessTimeout > 0 {
+		tracker := &helpers.ReconcileTracker{
+			Gatherer:    metrics.Registry,
+			Controllers: make([]string, 0),
+			Timeout:     livenessTimeout,
+		}
+		for _, c := range allControllers {
+			if enabledControllers[c] {
+				tracker.Controllers = append(tracker.Controllers, c)
+			}
+		}
+		if err := mgr.AddHealthzCheck("reconcile", tracker.Check); err != nil {
+			setupLog.Error(err, "unable to set up health check")
+			os.Exit(1)
+		}
+	}
+	if err := mgr.AddReadyzCheck("cache-sync", helpers.NewCacheSyncChecker(mgr.GetCache(), time.Second)); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
 
+	//The CRDs are installed with the install subcommand,
+	//the operator only checks the APIs of the enabled controllers are served
+	requiredAPIs := make([]schema.GroupVersionKind, 0)
+	for _, c := range allControllers {
+		if enabledControllers[c] {
+			requiredAPIs = append(requiredAPIs, controllerAPIs[c]...)
+		}
+	}
+	if err := install.Verify(context.TODO(), apiExtensionClient, mgr.GetRESTMapper(), operatorVersion(), requiredAPIs...); err != nil {
+		setupLog.Error(err, "the required CRDs are not installed")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// allControllers are the controllers which can be enabled with --controllers
+var allControllers = []string{"strategy", "placementdecision", "clusteroauth", "garbagecollector"}
+
+// defaultLeaderElectionID is the leader election ID of the operator running all controllers
+const defaultLeaderElectionID = "cc3e3fdf.identitatem.io"
+
+// leaderElectionID returns the leader election ID of the enabled controllers, the base ID prefixed
+// with the enabled controllers unless all are enabled. The deployments running different controllers
+// so elect their own leader while the replicas of a deployment share the same.
+func leaderElectionID(base string, enabledControllers map[string]bool) string {
+	names := make([]string, 0, len(allControllers))
+	for _, c := range allControllers {
+		if enabledControllers[c] {
+			names = append(names, c)
+		}
+	}
+	if len(names) == len(allControllers) {
+		return base
+	}
+	return fmt.Sprintf("%s.%s", strings.Join(names, "-"), base)
+}
+
+// controllerAPIs are the kinds each controller needs
+var controllerAPIs = map[string][]schema.GroupVersionKind{
+	"strategy": {
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("Strategy"),
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("AuthRealm"),
+		clusterv1alpha1.GroupVersion.WithKind("Placement"),
+		dexoperatorv1alpha1.GroupVersion.WithKind("DexServer"),
+	},
+	"placementdecision": {
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("Strategy"),
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("AuthRealm"),
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("ClusterOAuth"),
+		clusterv1alpha1.GroupVersion.WithKind("Placement"),
+		clusterv1alpha1.GroupVersion.WithKind("PlacementDecision"),
+		clusterv1.GroupVersion.WithKind("ManagedCluster"),
+		workv1.GroupVersion.WithKind("ManifestWork"),
+		dexoperatorv1alpha1.GroupVersion.WithKind("DexClient"),
+		dexoperatorv1alpha1.GroupVersion.WithKind("DexServer"),
+		routev1.GroupVersion.WithKind("Route"),
+	},
+	"clusteroauth": {
+		identitatemiov1alpha1.SchemeGroupVersion.WithKind("ClusterOAuth"),
+		workv1.GroupVersion.WithKind("ManifestWork"),
+	},
+}
+
+// runInstall runs the install and upgrade subcommands which install the CRDs of the operator
+func runInstall(command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var kubeconfig string
+	var force bool
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	if command == "upgrade" {
+		fs.BoolVar(&force, "force", false, "Downgrade the CRDs installed by a newer version of the operator.")
+	}
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if len(kubeconfig) != 0 {
+		if err := flag.CommandLine.Set("kubeconfig", kubeconfig); err != nil {
+			return err
+		}
+	}
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	apiExtensionClient, err := apiextensionsclient.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	setupLog.Info("installing CRDs", "version", operatorVersion(), "upgrade", command == "upgrade")
+	return install.Install(context.TODO(), apiExtensionClient, operatorVersion(), command == "upgrade", force)
+}
+
+//go:embed COMPONENT_VERSION
+var componentVersion string
+
+// operatorVersion returns the version of the operator
+func operatorVersion() string {
+	return strings.TrimSpace(componentVersion)
+}
+
+// parseControllers returns the controllers enabled by the --controllers flag
+func parseControllers(controllers string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	disabled := make(map[string]bool)
+	all := false
+	for _, name := range strings.Split(controllers, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if name == "*" {
+			all = true
+			continue
+		}
+		known := false
+		for _, c := range allControllers {
+			known = known || c == strings.TrimPrefix(name, "-")
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown controller %q", name)
+		}
+		if strings.HasPrefix(name, "-") {
+			disabled[strings.TrimPrefix(name, "-")] = true
+		} else {
+			enabled[name] = true
+		}
+	}
+	for _, c := range allControllers {
+		enabled[c] = (all || enabled[c]) && !disabled[c]
+	}
+	return enabled, nil
+}
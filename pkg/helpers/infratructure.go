@@ -22,10 +22,10 @@ func infrastructureConfigNameNsN() types.NamespacedName {
 	}
 }
 
-func GetKubeAPIServerAddress(client client.Client) (string, error) {
+func GetKubeAPIServerAddress(ctx context.Context, client client.Client) (string, error) {
 	infraConfig := &ocinfrav1.Infrastructure{}
 
-	if err := client.Get(context.TODO(), infrastructureConfigNameNsN(), infraConfig); err != nil {
+	if err := client.Get(ctx, infrastructureConfigNameNsN(), infraConfig); err != nil {
 		return "", err
 	}
 
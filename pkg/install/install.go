@@ -0,0 +1,149 @@
+// Copyright Red Hat
+
+// Package install installs and upgrades the CRDs owned by the operator
+// and verifies at startup that the CRDs the controllers need are served.
+package install
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ghodss/yaml"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	idpconfig "github.com/identitatem/idp-client-api/config"
+)
+
+const (
+	// VersionAnnotation is set on the installed CRDs with the version of the operator which installed them
+	VersionAnnotation string = "identityconfig.identitatem.io/installed-version"
+)
+
+// crdFiles are the CRDs owned by the operator
+var crdFiles = []string{
+	"crd/bases/identityconfig.identitatem.io_strategies.yaml",
+	"crd/bases/identityconfig.identitatem.io_clusteroauths.yaml",
+}
+
+// Install creates the CRDs owned by the operator and records the operator version on them.
+// With upgrade, the CRDs installed by another version are updated, a downgrade requires force.
+// Without upgrade, the CRDs installed by another version are left unchanged and an error is returned.
+func Install(ctx context.Context, client apiextensionsclient.Interface, operatorVersion string, upgrade, force bool) error {
+	v, err := version.ParseGeneric(operatorVersion)
+	if err != nil {
+		return fmt.Errorf("invalid operator version %q: %w", operatorVersion, err)
+	}
+	reader := idpconfig.GetScenarioResourcesReader()
+	for _, file := range crdFiles {
+		b, err := reader.Asset(file)
+		if err != nil {
+			return err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(b, crd); err != nil {
+			return err
+		}
+		if crd.Annotations == nil {
+			crd.Annotations = map[string]string{}
+		}
+		crd.Annotations[VersionAnnotation] = operatorVersion
+
+		existing, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crd.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			if _, err := client.ApiextensionsV1().CustomResourceDefinitions().Create(ctx, crd, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			installedVersion := existing.GetAnnotations()[VersionAnnotation]
+			if installedVersion == operatorVersion {
+				break
+			}
+			if !upgrade {
+				return fmt.Errorf("CRD %s is already installed by version %q, run upgrade to install version %s",
+					crd.Name, installedVersion, operatorVersion)
+			}
+			if iv, err := version.ParseGeneric(installedVersion); err == nil && v.LessThan(iv) && !force {
+				return fmt.Errorf("CRD %s is installed by the newer version %s, use --force to downgrade it to version %s",
+					crd.Name, installedVersion, operatorVersion)
+			}
+			existing.Spec = crd.Spec
+			if existing.Annotations == nil {
+				existing.Annotations = map[string]string{}
+			}
+			existing.Annotations[VersionAnnotation] = operatorVersion
+			if _, err := client.ApiextensionsV1().CustomResourceDefinitions().Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := waitEstablished(ctx, client, crd.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitEstablished waits for the CRD to be served
+func waitEstablished(ctx context.Context, client apiextensionsclient.Interface, name string) error {
+	return wait.PollImmediate(time.Second, time.Minute, func() (bool, error) {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range crd.Status.Conditions {
+			if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// Verify checks the kinds are served. The CRDs installed by an older version of the operator
+// must be upgraded before the operator starts.
+func Verify(ctx context.Context,
+	client apiextensionsclient.Interface,
+	mapper meta.RESTMapper,
+	operatorVersion string,
+	gvks ...schema.GroupVersionKind) error {
+	v, err := version.ParseGeneric(operatorVersion)
+	if err != nil {
+		return fmt.Errorf("invalid operator version %q: %w", operatorVersion, err)
+	}
+	for _, gvk := range gvks {
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return fmt.Errorf("%s is not served, run install to install the CRDs of the operator "+
+				"and check the CRDs of its dependencies are installed: %w", gvk, err)
+		}
+		crdName := fmt.Sprintf("%s.%s", mapping.Resource.Resource, mapping.Resource.Group)
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				//Built-in kind
+				continue
+			}
+			return err
+		}
+		installedVersion, ok := crd.GetAnnotations()[VersionAnnotation]
+		if !ok {
+			//Installed by other means
+			continue
+		}
+		if iv, err := version.ParseGeneric(installedVersion); err != nil || iv.LessThan(v) {
+			return fmt.Errorf("CRD %s is installed by version %q, run upgrade to install version %s",
+				crdName, installedVersion, operatorVersion)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright Red Hat
+
+package install
+
+import (
+	"context"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const strategiesCRD = "strategies.identityconfig.identitatem.io"
+
+var strategyGVK = schema.GroupVersionKind{Group: "identityconfig.identitatem.io", Version: "v1alpha1", Kind: "Strategy"}
+
+// newFakeClient returns a client whose CRDs are established as soon as they are written
+func newFakeClient(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	establish := func(action clienttesting.Action) (bool, runtime.Object, error) {
+		var obj runtime.Object
+		switch a := action.(type) {
+		case clienttesting.CreateAction:
+			obj = a.GetObject()
+		case clienttesting.UpdateAction:
+			obj = a.GetObject()
+		}
+		if crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition); ok {
+			crd.Status.Conditions = []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			}
+		}
+		return false, nil, nil
+	}
+	client.PrependReactor("create", "customresourcedefinitions", establish)
+	client.PrependReactor("update", "customresourcedefinitions", establish)
+	return client
+}
+
+func installedVersion(t *testing.T, client *fake.Clientset) string {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), strategiesCRD, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crd.Annotations[VersionAnnotation]
+}
+
+func TestInstall(t *testing.T) {
+	tests := []struct {
+		name        string
+		installed   string
+		upgrade     bool
+		force       bool
+		wantErr     bool
+		wantVersion string
+	}{
+		{
+			name:        "install",
+			wantVersion: "0.2.0",
+		},
+		{
+			name:        "install again",
+			installed:   "0.2.0",
+			wantVersion: "0.2.0",
+		},
+		{
+			name:        "install over another version",
+			installed:   "0.1.0",
+			wantErr:     true,
+			wantVersion: "0.1.0",
+		},
+		{
+			name:        "upgrade",
+			installed:   "0.1.0",
+			upgrade:     true,
+			wantVersion: "0.2.0",
+		},
+		{
+			name:        "downgrade",
+			installed:   "0.3.0",
+			upgrade:     true,
+			wantErr:     true,
+			wantVersion: "0.3.0",
+		},
+		{
+			name:        "forced downgrade",
+			installed:   "0.3.0",
+			upgrade:     true,
+			force:       true,
+			wantVersion: "0.2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if len(tt.installed) != 0 {
+				objects = append(objects, &apiextensionsv1.CustomResourceDefinition{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        strategiesCRD,
+						Annotations: map[string]string{VersionAnnotation: tt.installed},
+					},
+					Status: apiextensionsv1.CustomResourceDefinitionStatus{
+						Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+							{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+						},
+					},
+				})
+			}
+			client := newFakeClient(objects...)
+			err := Install(context.TODO(), client, "0.2.0", tt.upgrade, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Install() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := installedVersion(t, client); got != tt.wantVersion {
+				t.Errorf("installed version = %v, want %v", got, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(strategyGVK, meta.RESTScopeNamespace)
+	tests := []struct {
+		name      string
+		installed *string
+		served    bool
+		wantErr   bool
+	}{
+		{
+			name:    "not served",
+			wantErr: true,
+		},
+		{
+			name:      "installed by the same version",
+			installed: stringPtr("0.2.0"),
+			served:    true,
+		},
+		{
+			name:      "installed by a newer version",
+			installed: stringPtr("0.3.0"),
+			served:    true,
+		},
+		{
+			name:      "installed by an older version",
+			installed: stringPtr("0.1.0"),
+			served:    true,
+			wantErr:   true,
+		},
+		{
+			name:   "installed by other means",
+			served: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crd := &apiextensionsv1.CustomResourceDefinition{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: strategiesCRD,
+				},
+			}
+			if tt.installed != nil {
+				crd.Annotations = map[string]string{VersionAnnotation: *tt.installed}
+			}
+			client := newFakeClient(crd)
+			var m meta.RESTMapper = meta.NewDefaultRESTMapper(nil)
+			if tt.served {
+				m = mapper
+			}
+			err := Verify(context.TODO(), client, m, "0.2.0", strategyGVK)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,155 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+const (
+	// OwnerLabel is set on the ManifestWorks with the field manager of the controller owning their manifests
+	OwnerLabel string = "identityconfig.identitatem.io/manifestwork-owner"
+)
+
+// Get returns the ManifestWork, nil if it doesn't exist
+func Get(ctx context.Context, c client.Client, key client.ObjectKey) (*workv1.ManifestWork, error) {
+	mw := &workv1.ManifestWork{}
+	if err := c.Get(ctx, key, mw); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return mw, nil
+}
+
+// Apply server-side applies the manifests, labels and annotations of the ManifestWork with the field manager
+// if the existing ManifestWork doesn't deliver the same manifests or misses some of the labels.
+// The ManifestWork is labeled with the field manager as owner. It returns true if the ManifestWork was applied.
+// The annotations previously applied by the field manager and missing in the ManifestWork are removed.
+// The annotations are not compared, they are only applied with the manifests or the labels,
+// use Annotate to change the annotations of an up to date ManifestWork.
+func Apply(ctx context.Context, c client.Client, mw *workv1.ManifestWork, fieldManager string) (bool, error) {
+	hash, err := Hash(mw.Spec.Workload.Manifests)
+	if err != nil {
+		return false, err
+	}
+	existing, err := Get(ctx, c, client.ObjectKeyFromObject(mw))
+	if err != nil {
+		return false, err
+	}
+	needsUpdate, err := NeedsUpdate(existing, hash)
+	if err != nil {
+		return false, err
+	}
+	if !needsUpdate && hasLabels(existing, mw.Labels, fieldManager) {
+		return false, nil
+	}
+
+	applied := &workv1.ManifestWork{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: workv1.SchemeGroupVersion.String(),
+			Kind:       "ManifestWork",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        mw.Name,
+			Namespace:   mw.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: mw.Spec,
+	}
+	for k, v := range mw.Labels {
+		applied.Labels[k] = v
+	}
+	applied.Labels[OwnerLabel] = fieldManager
+	for k, v := range mw.Annotations {
+		applied.Annotations[k] = v
+	}
+	applied.Annotations[HashAnnotation] = hash
+	return true, c.Patch(ctx, applied, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// hasLabels returns true if the existing ManifestWork has the labels and is labeled with the field manager as owner
+func hasLabels(existing *workv1.ManifestWork, labels map[string]string, fieldManager string) bool {
+	existingLabels := existing.GetLabels()
+	if existingLabels[OwnerLabel] != fieldManager {
+		return false
+	}
+	for k, v := range labels {
+		if value, ok := existingLabels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Annotate server-side applies the annotations on an existing ManifestWork with the field manager,
+// the ManifestWork is not created if it doesn't exist. It returns false if the ManifestWork doesn't exist.
+func Annotate(ctx context.Context, c client.Client, key client.ObjectKey, annotations map[string]string, fieldManager string) (bool, error) {
+	existing, err := Get(ctx, c, key)
+	if err != nil || existing == nil {
+		return false, err
+	}
+	mw := &workv1.ManifestWork{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: workv1.SchemeGroupVersion.String(),
+			Kind:       "ManifestWork",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Annotations: annotations,
+		},
+	}
+	return true, c.Patch(ctx, mw, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// Delete deletes the ManifestWork, its finalizers are removed first if removeFinalizers is set
+func Delete(ctx context.Context, c client.Client, key client.ObjectKey, removeFinalizers bool) error {
+	mw, err := Get(ctx, c, key)
+	if err != nil || mw == nil {
+		return err
+	}
+	if removeFinalizers && len(mw.GetFinalizers()) > 0 {
+		mw.SetFinalizers([]string{})
+		if err := c.Update(ctx, mw); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if mw.DeletionTimestamp != nil {
+		return nil
+	}
+	if err := c.Delete(ctx, mw); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Prune deletes the ManifestWorks of the cluster namespace owned by the field manager
+// except the ones named keep
+func Prune(ctx context.Context, c client.Client, namespace, fieldManager string, keep ...string) error {
+	mws := &workv1.ManifestWorkList{}
+	if err := c.List(ctx, mws,
+		client.InNamespace(namespace),
+		client.MatchingLabels{OwnerLabel: fieldManager}); err != nil {
+		return err
+	}
+	kept := sets.NewString(keep...)
+	for i := range mws.Items {
+		if kept.Has(mws.Items[i].Name) || mws.Items[i].DeletionTimestamp != nil {
+			continue
+		}
+		if err := c.Delete(ctx, &mws.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
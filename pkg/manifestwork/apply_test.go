@@ -0,0 +1,58 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+func TestHasLabels(t *testing.T) {
+	newManifestWork := func(labels map[string]string) *workv1.ManifestWork {
+		return &workv1.ManifestWork{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		existing *workv1.ManifestWork
+		labels   map[string]string
+		expected bool
+	}{
+		{
+			name:     "labeled",
+			existing: newManifestWork(map[string]string{OwnerLabel: "my-manager", "app": "idp", "other": "value"}),
+			labels:   map[string]string{"app": "idp"},
+			expected: true,
+		},
+		{
+			name:     "missing label",
+			existing: newManifestWork(map[string]string{OwnerLabel: "my-manager"}),
+			labels:   map[string]string{"app": "idp"},
+			expected: false,
+		},
+		{
+			name:     "other label value",
+			existing: newManifestWork(map[string]string{OwnerLabel: "my-manager", "app": "other"}),
+			labels:   map[string]string{"app": "idp"},
+			expected: false,
+		},
+		{
+			name:     "other owner",
+			existing: newManifestWork(map[string]string{OwnerLabel: "other-manager", "app": "idp"}),
+			labels:   map[string]string{"app": "idp"},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasLabels(tt.existing, tt.labels, "my-manager"); got != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
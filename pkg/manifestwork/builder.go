@@ -0,0 +1,107 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+// Builder builds a ManifestWork from typed objects
+type Builder struct {
+	scheme       *runtime.Scheme
+	manifestWork *workv1.ManifestWork
+	err          error
+}
+
+// NewBuilder returns a builder of the ManifestWork name in the cluster namespace,
+// the scheme is used to set the TypeMeta of the objects which don't have one.
+func NewBuilder(scheme *runtime.Scheme, name, namespace string) *Builder {
+	return &Builder{
+		scheme: scheme,
+		manifestWork: &workv1.ManifestWork{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: workv1.ManifestWorkSpec{
+				Workload: workv1.ManifestsTemplate{
+					Manifests: []workv1.Manifest{},
+				},
+			},
+		},
+	}
+}
+
+// Add adds the objects to the manifests, the objects are not modified
+func (b *Builder) Add(objs ...runtime.Object) *Builder {
+	for _, obj := range objs {
+		if b.err != nil {
+			return b
+		}
+		obj = obj.DeepCopyObject()
+		if obj.GetObjectKind().GroupVersionKind().Empty() {
+			gvk, err := apiutil.GVKForObject(obj, b.scheme)
+			if err != nil {
+				b.err = err
+				return b
+			}
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			b.err = fmt.Errorf("unable to marshal %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, err)
+			return b
+		}
+		b.manifestWork.Spec.Workload.Manifests = append(b.manifestWork.Spec.Workload.Manifests,
+			workv1.Manifest{RawExtension: runtime.RawExtension{Raw: data}})
+	}
+	return b
+}
+
+// AddManifests adds manifests already serialized, for example the manifests of a previous revision
+func (b *Builder) AddManifests(manifests ...workv1.Manifest) *Builder {
+	b.manifestWork.Spec.Workload.Manifests = append(b.manifestWork.Spec.Workload.Manifests, manifests...)
+	return b
+}
+
+// WithLabel sets a label on the ManifestWork
+func (b *Builder) WithLabel(key, value string) *Builder {
+	if b.manifestWork.Labels == nil {
+		b.manifestWork.Labels = map[string]string{}
+	}
+	b.manifestWork.Labels[key] = value
+	return b
+}
+
+// WithAnnotation sets an annotation on the ManifestWork
+func (b *Builder) WithAnnotation(key, value string) *Builder {
+	if b.manifestWork.Annotations == nil {
+		b.manifestWork.Annotations = map[string]string{}
+	}
+	b.manifestWork.Annotations[key] = value
+	return b
+}
+
+// Build returns the ManifestWork with the hash of its manifests in the HashAnnotation
+func (b *Builder) Build() (*workv1.ManifestWork, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	hash, err := Hash(b.manifestWork.Spec.Workload.Manifests)
+	if err != nil {
+		return nil, err
+	}
+	mw := b.manifestWork.DeepCopy()
+	if mw.Annotations == nil {
+		mw.Annotations = map[string]string{}
+	}
+	mw.Annotations[HashAnnotation] = hash
+	return mw, nil
+}
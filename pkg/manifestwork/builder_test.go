@@ -0,0 +1,52 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestBuilder(t *testing.T) {
+	secret := newSecret()
+	secret.TypeMeta.Reset()
+
+	mw, err := NewBuilder(scheme.Scheme, "my-work", "my-cluster").
+		Add(secret, newConfigMap()).
+		WithAnnotation("my-annotation", "value").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mw.Name != "my-work" || mw.Namespace != "my-cluster" {
+		t.Errorf("unexpected ManifestWork %s/%s", mw.Namespace, mw.Name)
+	}
+	if len(secret.Kind) != 0 {
+		t.Errorf("the added object was modified")
+	}
+	if len(mw.Spec.Workload.Manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(mw.Spec.Workload.Manifests))
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(mw.Spec.Workload.Manifests[0].Raw); err != nil {
+		t.Fatal(err)
+	}
+	if u.GroupVersionKind() != corev1.SchemeGroupVersion.WithKind("Secret") {
+		t.Errorf("expected the TypeMeta of a Secret, got %s", u.GroupVersionKind())
+	}
+
+	hash, err := Hash(mw.Spec.Workload.Manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mw.Annotations[HashAnnotation] != hash {
+		t.Errorf("expected hash %s, got %s", hash, mw.Annotations[HashAnnotation])
+	}
+	if mw.Annotations["my-annotation"] != "value" {
+		t.Errorf("missing annotation my-annotation")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright Red Hat
+
+// Package manifestwork contains the helpers shared by the strategies to generate ManifestWorks
+package manifestwork
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ghodss/yaml"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+const (
+	// HashAnnotation is set on the ManifestWorks with the hash of their manifests
+	HashAnnotation string = "identityconfig.identitatem.io/manifests-hash"
+)
+
+// serverMetadataFields are the metadata fields set by the API server, they are not part of the desired state
+var serverMetadataFields = []string{
+	"creationTimestamp",
+	"deletionGracePeriodSeconds",
+	"deletionTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// Normalize returns the canonical JSON form of a manifest:
+// the null values, the status and the metadata set by the API server are removed,
+// the stringData of the Secrets is merged into their data and the keys are sorted.
+// Two manifests with the same canonical form result in the same object on the managed cluster.
+func Normalize(raw []byte) ([]byte, error) {
+	data, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	obj := map[string]interface{}{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	delete(obj, "status")
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		for _, field := range serverMetadataFields {
+			delete(metadata, field)
+		}
+	}
+	if obj["apiVersion"] == "v1" && obj["kind"] == "Secret" {
+		if err := mergeStringData(obj); err != nil {
+			return nil, err
+		}
+	}
+	pruneNulls(obj)
+
+	// encoding/json sorts the keys of the maps
+	return json.Marshal(obj)
+}
+
+// mergeStringData moves the stringData of a Secret into its data as the API server does
+func mergeStringData(secret map[string]interface{}) error {
+	stringData, ok := secret["stringData"].(map[string]interface{})
+	if !ok {
+		delete(secret, "stringData")
+		return nil
+	}
+	data, ok := secret["data"].(map[string]interface{})
+	if !ok {
+		data = map[string]interface{}{}
+	}
+	for k, v := range stringData {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("stringData %s of secret is not a string", k)
+		}
+		data[k] = base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	secret["data"] = data
+	delete(secret, "stringData")
+	return nil
+}
+
+// pruneNulls removes the null values of the maps, a null value is the same as no value
+func pruneNulls(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			pruneNulls(e)
+		}
+	case []interface{}:
+		for _, e := range v {
+			pruneNulls(e)
+		}
+	}
+}
+
+// Hash returns the hash of the canonical form of the manifests.
+// The hash doesn't depend on the order of the manifests.
+func Hash(manifests []workv1.Manifest) (string, error) {
+	hashes := make([]string, len(manifests))
+	for i, m := range manifests {
+		normalized, err := Normalize(m.Raw)
+		if err != nil {
+			return "", fmt.Errorf("unable to normalize manifest %d: %w", i, err)
+		}
+		hashes[i] = fmt.Sprintf("%x", sha256.Sum256(normalized))
+	}
+	sort.Strings(hashes)
+	h := sha256.New()
+	for _, hash := range hashes {
+		h.Write([]byte(hash))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// NeedsUpdate returns true if the existing ManifestWork doesn't deliver the manifests of the given hash,
+// either because it was generated from other manifests or because its manifests were modified.
+func NeedsUpdate(existing *workv1.ManifestWork, hash string) (bool, error) {
+	if existing == nil || existing.GetAnnotations()[HashAnnotation] != hash {
+		return true, nil
+	}
+	existingHash, err := Hash(existing.Spec.Workload.Manifests)
+	if err != nil {
+		return false, err
+	}
+	return existingHash != hash, nil
+}
@@ -0,0 +1,207 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+func toManifest(t *testing.T, obj interface{}) workv1.Manifest {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return workv1.Manifest{RawExtension: runtime.RawExtension{Raw: data}}
+}
+
+func newSecret() *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-secret",
+			Namespace: "openshift-config",
+		},
+		Data: map[string][]byte{
+			"clientSecret": []byte("secret"),
+		},
+	}
+}
+
+func newConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-ca",
+			Namespace: "openshift-config",
+		},
+		Data: map[string]string{
+			"ca.crt": "ca",
+		},
+	}
+}
+
+func TestHash(t *testing.T) {
+	secret := newSecret()
+	configMap := newConfigMap()
+	reference, err := Hash([]workv1.Manifest{toManifest(t, secret), toManifest(t, configMap)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labeled := newSecret()
+	labeled.Labels = map[string]string{"app": "idp"}
+	annotated := newSecret()
+	annotated.Annotations = map[string]string{"note": "value"}
+	withServerFields := newSecret()
+	withServerFields.ResourceVersion = "42"
+	withServerFields.UID = "uid"
+	withServerFields.CreationTimestamp = metav1.Now()
+
+	tests := []struct {
+		name      string
+		manifests []workv1.Manifest
+		same      bool
+	}{
+		{
+			name:      "reordered manifests",
+			manifests: []workv1.Manifest{toManifest(t, configMap), toManifest(t, secret)},
+			same:      true,
+		},
+		{
+			name: "reordered keys and yaml",
+			manifests: []workv1.Manifest{
+				{RawExtension: runtime.RawExtension{Raw: []byte(`
+kind: Secret
+apiVersion: v1
+metadata:
+  namespace: openshift-config
+  name: my-secret
+data:
+  clientSecret: c2VjcmV0
+`)}},
+				toManifest(t, configMap),
+			},
+			same: true,
+		},
+		{
+			name: "stringData",
+			manifests: []workv1.Manifest{
+				toManifest(t, map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Secret",
+					"metadata": map[string]interface{}{
+						"name":      "my-secret",
+						"namespace": "openshift-config",
+					},
+					"stringData": map[string]interface{}{
+						"clientSecret": "secret",
+					},
+				}),
+				toManifest(t, configMap),
+			},
+			same: true,
+		},
+		{
+			name:      "server fields",
+			manifests: []workv1.Manifest{toManifest(t, withServerFields), toManifest(t, configMap)},
+			same:      true,
+		},
+		{
+			name:      "labels",
+			manifests: []workv1.Manifest{toManifest(t, labeled), toManifest(t, configMap)},
+			same:      false,
+		},
+		{
+			name:      "annotations",
+			manifests: []workv1.Manifest{toManifest(t, annotated), toManifest(t, configMap)},
+			same:      false,
+		},
+		{
+			name:      "missing manifest",
+			manifests: []workv1.Manifest{toManifest(t, secret)},
+			same:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := Hash(tt.manifests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (hash == reference) != tt.same {
+				t.Errorf("expected same hash %t, got %s and %s", tt.same, hash, reference)
+			}
+		})
+	}
+}
+
+func TestNeedsUpdate(t *testing.T) {
+	manifests := []workv1.Manifest{toManifest(t, newSecret())}
+	hash, err := Hash(manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newManifestWork := func(hash string, manifests []workv1.Manifest) *workv1.ManifestWork {
+		return &workv1.ManifestWork{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{HashAnnotation: hash},
+			},
+			Spec: workv1.ManifestWorkSpec{
+				Workload: workv1.ManifestsTemplate{Manifests: manifests},
+			},
+		}
+	}
+	modified := newSecret()
+	modified.Data["clientSecret"] = []byte("modified")
+
+	tests := []struct {
+		name     string
+		existing *workv1.ManifestWork
+		expected bool
+	}{
+		{
+			name:     "missing",
+			existing: nil,
+			expected: true,
+		},
+		{
+			name:     "up to date",
+			existing: newManifestWork(hash, manifests),
+			expected: false,
+		},
+		{
+			name:     "other hash",
+			existing: newManifestWork("other", manifests),
+			expected: true,
+		},
+		{
+			name:     "modified manifests",
+			existing: newManifestWork(hash, []workv1.Manifest{toManifest(t, modified)}),
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			needsUpdate, err := NeedsUpdate(tt.existing, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if needsUpdate != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, needsUpdate)
+			}
+		})
+	}
+}
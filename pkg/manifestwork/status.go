@@ -0,0 +1,56 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+// Status is the state of the manifests of a ManifestWork on the managed cluster
+type Status struct {
+	// Available is true when all manifests are available on the managed cluster
+	Available bool
+	// Failed is true when the manifests are degraded or can't be applied on the managed cluster
+	Failed bool
+	// Message explains the failure
+	Message string
+}
+
+// Condition returns the condition of the ManifestWork if it reflects the current manifests.
+// The work agent may not set the observed generation, the transition time is then compared
+// to the time the manifests were applied.
+func Condition(mw *workv1.ManifestWork, conditionType string, appliedAt time.Time) *metav1.Condition {
+	condition := meta.FindStatusCondition(mw.Status.Conditions, conditionType)
+	if condition == nil {
+		return nil
+	}
+	if condition.ObservedGeneration == mw.Generation ||
+		(condition.ObservedGeneration == 0 && !condition.LastTransitionTime.Time.Before(appliedAt)) {
+		return condition
+	}
+	return nil
+}
+
+// GetStatus returns the state of the manifests applied at the given time,
+// the conditions set for previous manifests are ignored.
+func GetStatus(mw *workv1.ManifestWork, appliedAt time.Time) Status {
+	status := Status{}
+	degraded := Condition(mw, workv1.WorkDegraded, appliedAt)
+	applied := Condition(mw, workv1.WorkApplied, appliedAt)
+	available := Condition(mw, workv1.WorkAvailable, appliedAt)
+	switch {
+	case degraded != nil && degraded.Status == metav1.ConditionTrue:
+		status.Failed = true
+		status.Message = degraded.Message
+	case applied != nil && applied.Status == metav1.ConditionFalse:
+		status.Failed = true
+		status.Message = applied.Message
+	}
+	status.Available = !status.Failed && available != nil && available.Status == metav1.ConditionTrue
+	return status
+}
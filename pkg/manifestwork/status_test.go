@@ -0,0 +1,91 @@
+// Copyright Red Hat
+
+package manifestwork
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+func TestGetStatus(t *testing.T) {
+	appliedAt := time.Now()
+	before := metav1.NewTime(appliedAt.Add(-time.Minute))
+	after := metav1.NewTime(appliedAt.Add(time.Minute))
+
+	tests := []struct {
+		name       string
+		generation int64
+		conditions []metav1.Condition
+		expected   Status
+	}{
+		{
+			name:     "no condition",
+			expected: Status{},
+		},
+		{
+			name:       "available",
+			generation: 2,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, ObservedGeneration: 2},
+			},
+			expected: Status{Available: true},
+		},
+		{
+			name:       "available for previous generation",
+			generation: 2,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, ObservedGeneration: 1},
+			},
+			expected: Status{},
+		},
+		{
+			name:       "available before the apply",
+			generation: 2,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, LastTransitionTime: before},
+			},
+			expected: Status{},
+		},
+		{
+			name:       "available after the apply",
+			generation: 2,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, LastTransitionTime: after},
+			},
+			expected: Status{Available: true},
+		},
+		{
+			name:       "degraded",
+			generation: 1,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, ObservedGeneration: 1},
+				{Type: workv1.WorkDegraded, Status: metav1.ConditionTrue, ObservedGeneration: 1, Message: "degraded"},
+			},
+			expected: Status{Failed: true, Message: "degraded"},
+		},
+		{
+			name:       "not applied",
+			generation: 1,
+			conditions: []metav1.Condition{
+				{Type: workv1.WorkApplied, Status: metav1.ConditionFalse, ObservedGeneration: 1, Message: "not applied"},
+			},
+			expected: Status{Failed: true, Message: "not applied"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := &workv1.ManifestWork{
+				ObjectMeta: metav1.ObjectMeta{Generation: tt.generation},
+				Status:     workv1.ManifestWorkStatus{Conditions: tt.conditions},
+			}
+			status := GetStatus(mw, appliedAt)
+			if status != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, status)
+			}
+		})
+	}
+}
@@ -28,6 +28,7 @@ import (
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 
 	dexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
+	routev1 "github.com/openshift/api/route/v1"
 	dexoperatorconfig "github.com/identitatem/dex-operator/config"
 	identitatemclientset "github.com/identitatem/idp-client-api/api/client/clientset/versioned"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
@@ -91,6 +92,9 @@ var _ = BeforeSuite(func() {
 	err = dexv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).Should(BeNil())
 
+	err = routev1.AddToScheme(scheme.Scheme)
+	Expect(err).Should(BeNil())
+
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
@@ -108,6 +112,7 @@ var _ = BeforeSuite(func() {
 	readerDex := dexoperatorconfig.GetScenarioResourcesReader()
 	files = []string{
 		"crd/bases/auth.identitatem.io_dexclients.yaml",
+		"crd/bases/auth.identitatem.io_dexservers.yaml",
 	}
 	_, err = applier.ApplyDirectly(readerDex, nil, false, "", files...)
 	Expect(err).Should(BeNil())
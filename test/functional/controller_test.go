@@ -10,8 +10,10 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -21,7 +23,10 @@ import (
 	dexv1alpha1 "github.com/identitatem/dex-operator/api/v1alpha1"
 	identitatemv1alpha1 "github.com/identitatem/idp-client-api/api/identitatem/v1alpha1"
 	openshiftconfigv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
 	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+
+	"github.com/identitatem/idp-strategy-operator/controllers/helpers"
 )
 
 var _ = Describe("Strategy", func() {
@@ -143,7 +148,7 @@ var _ = Describe("Strategy", func() {
 				var err error
 				strategy, err := identitatemClientSet.IdentityconfigV1alpha1().Strategies(AuthRealmNameSpace).Get(context.TODO(), StrategyName, metav1.GetOptions{})
 				Expect(err).To(BeNil())
-				Expect(strategy.Spec.PlacementRef.Name).Should(Equal(PlacementStrategyName))
+				Expect(meta.IsStatusConditionTrue(strategy.Status.Conditions, "PlacementReady")).Should(BeTrue())
 			})
 			By("Checking placement strategy", func() {
 				_, err := clientSetCluster.ClusterV1alpha1().Placements(AuthRealmNameSpace).
@@ -164,11 +169,73 @@ var _ = Describe("Strategy", func() {
 			Expect(err).To(BeNil())
 		})
 
+		By(fmt.Sprintf("creation of the DexServer in namespace %s", AuthRealmName), func() {
+			dexServer := &dexv1alpha1.DexServer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("%s-%s", AuthRealmNameSpace, AuthRealmName),
+					Namespace: AuthRealmName,
+				},
+				Spec: dexv1alpha1.DexServerSpec{
+					Issuer: "https://dex.apps.example.com",
+				},
+			}
+			err := k8sClient.Create(context.TODO(), dexServer)
+			Expect(err).To(BeNil())
+
+			//The pause image is preloaded on the kind nodes, the Deployment becomes available
+			labels := map[string]string{"app": "dex"}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "dex",
+					Namespace: AuthRealmName,
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "dex", Image: "k8s.gcr.io/pause:3.5"}},
+						},
+					},
+				},
+			}
+			err = controllerutil.SetOwnerReference(dexServer, deployment, scheme.Scheme)
+			Expect(err).To(BeNil())
+			err = k8sClient.Create(context.TODO(), deployment)
+			Expect(err).To(BeNil())
+
+			//There is no router on kind, the route is admitted by the test
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "dex",
+					Namespace: AuthRealmName,
+				},
+				Spec: routev1.RouteSpec{
+					Host: "dex.apps.example.com",
+				},
+			}
+			err = k8sClient.Create(context.TODO(), route)
+			Expect(err).To(BeNil())
+			route.Status.Ingress = []routev1.RouteIngress{
+				{
+					Host: route.Spec.Host,
+					Conditions: []routev1.RouteIngressCondition{
+						{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue},
+					},
+				},
+			}
+			err = k8sClient.Status().Update(context.TODO(), route)
+			Expect(err).To(BeNil())
+		})
+
 		By("Create Placement Decision CR", func() {
 			placementDecision := &clusterv1alpha1.PlacementDecision{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      StrategyName,
+					Name:      PlacementStrategyName + "-decision-1",
 					Namespace: AuthRealmNameSpace,
+					Labels: map[string]string{
+						"cluster.open-cluster-management.io/placement": PlacementStrategyName,
+					},
 				},
 			}
 			placementDecision, err := clientSetCluster.ClusterV1alpha1().PlacementDecisions(AuthRealmNameSpace).
@@ -185,16 +252,20 @@ var _ = Describe("Strategy", func() {
 			Expect(err).To(BeNil())
 		})
 
-		dexClientName := fmt.Sprintf("%s-%s", ClusterName, MyIDPName)
-		By(fmt.Sprintf("Checking client secret %s", MyIDPName), func() {
+		authRealmKey := &identitatemv1alpha1.AuthRealm{
+			ObjectMeta: metav1.ObjectMeta{Name: AuthRealmName, Namespace: AuthRealmNameSpace},
+		}
+		dexClientName := helpers.DexClientName(authRealmKey, ClusterName, MyIDPName)
+		clientSecretName := helpers.ClientSecretName(authRealmKey, MyIDPName)
+		By(fmt.Sprintf("Checking client secret %s", clientSecretName), func() {
 			Eventually(func() error {
 				clientSecret := &corev1.Secret{}
-				err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: MyIDPName, Namespace: ClusterName}, clientSecret)
+				err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: clientSecretName, Namespace: ClusterName}, clientSecret)
 				if err != nil {
 					if !errors.IsNotFound(err) {
 						return err
 					}
-					logf.Log.Info("ClientSecret", "Name", MyIDPName, "Namespace", ClusterName)
+					logf.Log.Info("ClientSecret", "Name", clientSecretName, "Namespace", ClusterName)
 					return err
 				}
 				return nil